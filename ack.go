@@ -0,0 +1,189 @@
+package mego
+
+import (
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/vmihailenco/msgpack"
+)
+
+var (
+	// DefaultAckTimeout 是等待客戶端確認收到事件的預設秒數。
+	DefaultAckTimeout = 5
+	// DefaultAckRetries 是客戶端沒有確認收到時，預設最多重新傳送事件的次數。
+	DefaultAckRetries = 3
+)
+
+// AckFailureHandler 是事件在重新傳送數次後仍沒有被客戶端確認收到時所呼叫的處理函式。
+type AckFailureHandler func(sess *Session, delivery Delivery)
+
+// Delivery 呈現了一則需要客戶端確認收到的事件投遞。
+type Delivery struct {
+	// ID 是這則投遞的編號，客戶端會以此編號回報已經收到，也會以此捨棄重複收到的事件。
+	ID string
+	// Event 是事件名稱。
+	Event string
+	// Channel 是廣播此事件的頻道名稱。
+	Channel string
+	// Result 是這則事件的資料酬載。
+	Result interface{}
+	// Attempts 是這則事件已經傳送的次數。
+	Attempts int
+}
+
+// pendingDelivery 是一則正在等待客戶端確認收到的投遞。
+type pendingDelivery struct {
+	// delivery 是投遞的內容。
+	delivery Delivery
+	// msg 是已經編碼的訊息，重新傳送時不需要再次編碼。
+	msg []byte
+	// timer 是下次重新傳送的計時器。
+	timer Timer
+	// queued 表示此投遞已經被放入斷線階段的暫存佇列，重新連線前不需要再次傳送。
+	queued bool
+}
+
+// HandleAckFailure 會設置事件在重新傳送數次後仍沒有被客戶端確認收到時所呼叫的處理函式。
+func (e *Engine) HandleAckFailure(handler AckFailureHandler) *Engine {
+	e.ackFailureHandler = handler
+	return e
+}
+
+// EmitWithAck 會帶有指定資料並廣播指定事件與頻道，且不論事件選項為何都會要求每個訂閱者確認收到。
+// 沒有在期限內確認收到的訂閱者會依照退避時間被重新傳送，直到超過引擎選項的 `AckRetries` 為止。
+func (e *Engine) EmitWithAck(event string, channel string, result interface{}) error {
+	return e.emit(event, channel, result, nil, nil, true)
+}
+
+// eventOption 會回傳指定事件的選項，事件沒有被宣告時回傳空白的選項。
+func (e *Engine) eventOption(event string) EventOption {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	evt, ok := e.Events[event]
+	if !ok || evt.Option == nil {
+		return EventOption{}
+	}
+	return *evt.Option
+}
+
+// send 會將回應編碼一次後寫入給所有傳入的階段。當 `ack` 為 `true` 時，回應會帶有投遞編號，
+// 並在客戶端確認收到之前持續追蹤每個階段的投遞狀態。
+func (e *Engine) send(sessions []*Session, resp Response, ack bool) error {
+	if ack {
+		resp.Delivery = uuid.NewV4().String()
+	}
+	msg, err := msgpack.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	for _, v := range sessions {
+		if ack {
+			e.track(v, Delivery{
+				ID:       resp.Delivery,
+				Event:    resp.Event,
+				Channel:  resp.Channel,
+				Result:   resp.Result,
+				Attempts: 1,
+			}, msg)
+		}
+		v.writeBinary(msg)
+	}
+	return nil
+}
+
+// ackTimeout 會回傳第幾次傳送後應該等待多久才重新傳送，每次重新傳送的等待時間都會加倍。
+func (e *Engine) ackTimeout(attempts int) time.Duration {
+	timeout := e.Option.AckTimeout
+	if timeout == 0 {
+		timeout = DefaultAckTimeout
+	}
+	return time.Second * time.Duration(timeout) << uint(attempts-1)
+}
+
+// ackRetries 會回傳最多重新傳送的次數。
+func (e *Engine) ackRetries() int {
+	if e.Option.AckRetries == 0 {
+		return DefaultAckRetries
+	}
+	return e.Option.AckRetries
+}
+
+// track 會開始追蹤指定階段的投遞，並在逾期時重新傳送。已經被移除的階段則不會被追蹤。
+func (e *Engine) track(sess *Session, delivery Delivery, msg []byte) {
+	if sess.origin != nil {
+		sess = sess.origin
+	}
+	sess.lock.Lock()
+	defer sess.lock.Unlock()
+	if sess.closed {
+		return
+	}
+	// 斷線中的階段會將這次傳送的訊息放入暫存佇列。
+	p := &pendingDelivery{
+		delivery: delivery,
+		msg:      msg,
+		queued:   sess.detached,
+	}
+	if sess.deliveries == nil {
+		sess.deliveries = make(map[string]*pendingDelivery)
+	}
+	sess.deliveries[delivery.ID] = p
+	p.timer = e.clock().AfterFunc(e.ackTimeout(delivery.Attempts), func() {
+		e.retry(sess, delivery.ID)
+	})
+}
+
+// retry 會重新傳送尚未被確認收到的投遞，超過重新傳送次數時則放棄並呼叫失敗處理函式。
+// 階段斷線期間暫存佇列中只會有一份投遞，並算作一次傳送，其餘的重新傳送會延到客戶端重新連線之後。
+func (e *Engine) retry(sess *Session, id string) {
+	sess.lock.Lock()
+	p, ok := sess.deliveries[id]
+	if !ok {
+		sess.lock.Unlock()
+		return
+	}
+	if sess.detached && p.queued {
+		p.timer = e.clock().AfterFunc(e.ackTimeout(p.delivery.Attempts), func() {
+			e.retry(sess, id)
+		})
+		sess.lock.Unlock()
+		return
+	}
+	p.queued = sess.detached
+	if p.delivery.Attempts > e.ackRetries() {
+		delete(sess.deliveries, id)
+		sess.lock.Unlock()
+		if e.ackFailureHandler != nil {
+			e.ackFailureHandler(sess, p.delivery)
+		}
+		return
+	}
+	p.delivery.Attempts++
+	p.timer = e.clock().AfterFunc(e.ackTimeout(p.delivery.Attempts), func() {
+		e.retry(sess, id)
+	})
+	sess.lock.Unlock()
+
+	sess.writeBinary(p.msg)
+}
+
+// settle 會停止追蹤指定階段中已經被確認收到的投遞。
+func (s *Session) settle(id string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if p, ok := s.deliveries[id]; ok {
+		p.timer.Stop()
+		delete(s.deliveries, id)
+	}
+}
+
+// stopDeliveries 會停止追蹤此階段所有尚未被確認收到的投遞，用於階段被移除時，之後也不會再追蹤新的投遞。
+func (s *Session) stopDeliveries() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.closed = true
+	for _, p := range s.deliveries {
+		p.timer.Stop()
+	}
+	s.deliveries = nil
+}
@@ -0,0 +1,38 @@
+package mego
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAckDetached(t *testing.T) {
+	e, sess, _ := newTestEngine()
+	clock := e.Option.Clock.(*FakeClock)
+	e.Option.AckTimeout = 1
+	e.Option.AckRetries = 3
+	var failed []string
+	e.HandleAckFailure(func(s *Session, d Delivery) {
+		failed = append(failed, d.ID)
+	})
+
+	// 斷線中的階段只會在暫存佇列中保留一份投遞，重新傳送會等到重新連線之後。
+	assert.NoError(t, e.send([]*Session{sess}, Response{Event: "Event"}, true))
+	clock.Advance(time.Minute)
+	assert.Len(t, sess.queue, 1)
+	assert.Len(t, sess.deliveries, 1)
+	assert.Len(t, failed, 0)
+	for _, p := range sess.deliveries {
+		assert.Equal(t, 1, p.delivery.Attempts)
+	}
+
+	// 被移除的階段會停止所有的投遞，也不會再追蹤新的投遞。
+	e.removeSession(sess)
+	assert.Len(t, sess.deliveries, 0)
+	assert.NoError(t, e.send([]*Session{sess}, Response{Event: "Event"}, true))
+	assert.Len(t, sess.deliveries, 0)
+	clock.Advance(time.Minute)
+	assert.Len(t, sess.queue, 2)
+	assert.Len(t, failed, 0)
+}
@@ -1,10 +1,12 @@
 package client
 
 import (
+	"sync"
 	"time"
 
 	"github.com/TeaMeow/Mego"
 
+	mirror "github.com/TeaMeow/Mirror"
 	"github.com/gorilla/websocket"
 	uuid "github.com/satori/go.uuid"
 	"github.com/vmihailenco/msgpack"
@@ -20,20 +22,57 @@ var (
 	Timeout = time.Second * 15
 	// UploadTimeout 是每個區塊、所有檔案的上傳逾期秒數，`0` 表示無上限。
 	UploadTimeout = time.Second * 30
+	// ReconnectInterval 是自動重新連線時每次嘗試的間隔。
+	ReconnectInterval = time.Second * 3
+	// ChunkWindow 是區塊上傳時預設同時傳送中的區塊數量。
+	ChunkWindow = 4
+	// ChunkHash 是預設計算區塊雜湊時所使用的演算法。
+	ChunkHash = HashCRC32C
+	// FileRetries 是檔案或區塊因為內容損毀而被伺服端要求重新上傳時預設最多重試的次數。
+	FileRetries = 3
+	// DeliveryCacheSize 是客戶端最多記住幾個已經處理過的投遞編號，用以捨棄伺服端重複傳送的事件。
+	DeliveryCacheSize = 1024
 )
 
+const (
+	// CloseKicked 表示客戶端被伺服端踢除。
+	CloseKicked = 4000
+	// CloseBanned 表示客戶端因為被停權而斷線。
+	CloseBanned = 4001
+	// CloseDuplicateLogin 表示同個使用者在其他地方登入而使此連線被斷開。
+	CloseDuplicateLogin = 4002
+	// CloseShutdown 表示伺服端正在關閉。
+	CloseShutdown = 4003
+)
+
+// DisconnectReason 呈現了連線中斷的原因。
+type DisconnectReason struct {
+	// Code 是斷線原因代號，通常是 WebSocket 的關閉代碼。
+	Code int
+	// Message 是人類可讀的斷線原因。
+	Message string
+	// Kicked 表示此連線是被伺服端刻意斷開的，這種情況下除了 `CloseShutdown` 以外都不會自動重新連線。
+	Kicked bool
+}
+
 // New 能夠回傳一個新的客戶端。
 func New(url string) *Client {
 	return &Client{
 		URL:  url,
 		UUID: uuid.NewV4().String(),
 		Option: &Option{
-			ChunkSize:     ChunkSize,
-			Timeout:       Timeout,
-			UploadTimeout: UploadTimeout,
+			ChunkSize:         ChunkSize,
+			Timeout:           Timeout,
+			UploadTimeout:     UploadTimeout,
+			ReconnectInterval: ReconnectInterval,
+			ChunkWindow:       ChunkWindow,
+			ChunkHash:         ChunkHash,
+			FileRetries:       FileRetries,
 		},
-		requests: make(map[int]*Request),
-		keys:     make(map[string]interface{}),
+		requests:  make(map[int]*Request),
+		keys:      make(map[string]interface{}),
+		listeners: make(map[string]func(*Event)),
+		sequences: make(map[string]int),
 	}
 }
 
@@ -45,6 +84,16 @@ type Option struct {
 	Timeout time.Duration
 	// UploadTimeout 是每個區塊、所有檔案的上傳逾期秒數，`0` 表示無上限。
 	UploadTimeout time.Duration
+	// AutoReconnect 表示連線意外中斷時是否要自動重新連線，被伺服端刻意斷開時則不會重新連線。
+	AutoReconnect bool
+	// ReconnectInterval 是自動重新連線時每次嘗試的間隔。
+	ReconnectInterval time.Duration
+	// ChunkWindow 是區塊上傳時同時傳送中的區塊數量，在高延遲的連線中能大幅提升上傳速度。
+	ChunkWindow int
+	// ChunkHash 是計算區塊雜湊時所使用的演算法，可以是 `HashCRC32C` 或 `HashSHA256`，空字串表示不計算。
+	ChunkHash string
+	// FileRetries 是檔案或區塊因為內容損毀而被伺服端要求重新上傳時最多重試的次數。
+	FileRetries int
 }
 
 // Client 是一個客戶z端。
@@ -66,26 +115,69 @@ type Client struct {
 	listeners map[string]func(*Event)
 	// keys 是保存於遠端的鍵值組。
 	keys map[string]interface{}
-	// conn 是底層的 WebSocket 連線。
+	// conn 是底層的 WebSocket 連線，連線中斷後到重新連線成功之前為 `nil`。
 	conn *websocket.Conn
+	// generation 是每次連線成功或重新連線時都會遞增的連線世代，已經被取代的舊連線能以此得知不需要再處理斷線。
+	generation int
+	// resumeToken 是伺服端所核發的恢復權杖，重新連線時會夾帶此權杖以接回原本的階段與訂閱。
+	resumeToken string
+	// events 是等待交給監聽函式處理的事件佇列。
+	events []*Response
+	// dispatching 表示目前是否有正在處理事件佇列的 goroutine。
+	dispatching bool
+	// sequences 以事件與頻道名稱作為鍵名，存放最後接收到的事件序號，用以察覺遺漏的事件。
+	sequences map[string]int
+	// lock 是避免請求、監聽器等資料同時被讀取迴圈與呼叫者存取的互斥鎖。
+	lock sync.Mutex
+	// writeLock 確保同一時間只有一個訊息寫入底層連線。
+	writeLock sync.Mutex
+	// onDisconnect 是連線中斷時所會呼叫的處理函式。
+	onDisconnect func(DisconnectReason)
+	// kickReason 是伺服端斷開此連線前所告知的原因。
+	kickReason *DisconnectReason
+	// closed 表示連線是由使用者透過 `Close` 自行關閉的。
+	closed bool
+	// delivered 是已經處理過的投遞編號。
+	delivered map[string]bool
+	// deliveredOrder 依處理順序存放投遞編號，超過上限時會先忘記最舊的編號。
+	deliveredOrder []string
+	// states 以事件與頻道名稱作為鍵名，存放頻道共享狀態在本地的副本。
+	states map[string]*state
+	// stateListeners 是以事件名稱作為鍵名的共享狀態處理函式。
+	stateListeners map[string]StateHandler
 }
 
 // Call 能夠建立一個呼叫遠端指定方法的空白請求。
 func (c *Client) Call(method string) *Request {
-	if c.conn == nil {
+	c.lock.Lock()
+	conn := c.conn
+	c.lock.Unlock()
+	if conn == nil {
 		panic(ErrClosed)
 	}
-	//
+	return c.request(method)
+}
+
+// request 會建立一個呼叫遠端指定方法的空白請求，即使連線正在中斷中也不會 `panic`，
+// 發送時會由 `writeMessage` 回傳 `ErrClosed`，讓上傳等能夠在重新連線後重試的流程自行處理。
+func (c *Client) request(method string) *Request {
+	// 遞增請求編號。
+	c.lock.Lock()
 	c.taskID++
-	//
+	id := c.taskID
+	c.lock.Unlock()
+
 	return &Request{
 		Method: method,
 		Files:  make(map[string][]*File),
-		ID:     c.taskID,
+		ID:     id,
 		Option: &RequestOption{
 			ChunkSize:     c.Option.ChunkSize,
 			Timeout:       c.Option.Timeout,
 			UploadTimeout: c.Option.UploadTimeout,
+			ChunkWindow:   c.Option.ChunkWindow,
+			ChunkHash:     c.Option.ChunkHash,
+			FileRetries:   c.Option.FileRetries,
 		},
 		response: make(chan *Response, 1),
 		client:   c,
@@ -95,6 +187,8 @@ func (c *Client) Call(method string) *Request {
 // Set 能夠將指定資料保存於遠端伺服器，避免每次請求都需傳遞相同資料供伺服端讀取。
 // 由於資料保存在指定伺服器上，若使用負載平衡可能導致另一個伺服器找不到相關資料，因此使用負載平衡時請不要用上此方式。
 func (c *Client) Set(data map[string]interface{}) *Client {
+	c.lock.Lock()
+	defer c.lock.Unlock()
 	for k, v := range data {
 		c.keys[k] = v
 	}
@@ -111,12 +205,21 @@ func (c *Client) Connect() error {
 	//defer c.Close()
 
 	// 將連線保存至客戶端建構體內。
+	c.lock.Lock()
 	c.conn = conn
+	c.closed = false
+	c.kickReason = nil
+	c.generation++
+	generation := c.generation
+	c.lock.Unlock()
 
-	// 不斷呼叫訊息處理函式。
+	// 不斷呼叫訊息處理函式，直到連線中斷為止。
 	go func() {
 		for {
-			c.messageHandler()
+			if err := c.messageHandler(conn); err != nil {
+				c.disconnected(generation, err)
+				return
+			}
 		}
 	}()
 
@@ -129,8 +232,17 @@ func (c *Client) Connect() error {
 // initializeConn 會初始化一個連線，並傳送初始鍵值組至遠端伺服器保存。
 func (c *Client) initializeConn() error {
 	// 將自己的客戶端 UUID 納入鍵值組中。
-	keys := c.keys
+	c.lock.Lock()
+	keys := make(map[string]interface{}, len(c.keys)+2)
+	for k, v := range c.keys {
+		keys[k] = v
+	}
 	keys["MegoID"] = c.UUID
+	// 如果先前有取得恢復權杖，就一併傳送以便接回原本的階段。
+	if c.resumeToken != "" {
+		keys["MegoResumeToken"] = c.resumeToken
+	}
+	c.lock.Unlock()
 
 	// 傳送鍵值組至伺服端，啟動連線後的第一個訊息會被作為初始訊息。
 	return c.writeMessage(Request{
@@ -144,70 +256,369 @@ func (c *Client) writeMessage(data Request) error {
 	if err != nil {
 		return err
 	}
-	return c.conn.WriteMessage(websocket.TextMessage, msg)
+	c.lock.Lock()
+	conn := c.conn
+	c.lock.Unlock()
+	if conn == nil {
+		return ErrClosed
+	}
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	return conn.WriteMessage(websocket.TextMessage, msg)
 }
 
-//
-func (c *Client) messageHandler() {
+// messageHandler 會讀取並處理來自指定連線的一則訊息，讀取失敗時會回傳錯誤。
+func (c *Client) messageHandler(conn *websocket.Conn) error {
 	// 持續接收訊息。
-	m, msg, err := c.conn.ReadMessage()
+	m, msg, err := conn.ReadMessage()
 	if err != nil {
-		panic(err)
+		return err
 	}
 	if m == -1 {
-		return
+		return nil
 	}
 
 	// 將接收到的訊息從 MessagePack 格式映射回本地的回應建構體。
 	var resp *Response
 	if err := msgpack.Unmarshal(msg, &resp); err != nil {
-		panic(err)
+		return nil
 	}
 
 	// 如果回應沒有編號，又有事件名稱則表示自訂事件。
 	if resp.ID == 0 && resp.Event != "" {
-		//
-		return
+		switch resp.Event {
+		// 伺服端核發了恢復權杖，保存下來供重新連線時使用。
+		case "MegoResume":
+			var r struct {
+				Token string
+			}
+			if err := mirror.Cast(resp.Result, &r); err == nil {
+				c.lock.Lock()
+				c.resumeToken = r.Token
+				c.lock.Unlock()
+			}
+		// 伺服端即將斷開此連線，保存斷線原因供斷線處理函式使用。
+		case "MegoKicked":
+			var r DisconnectReason
+			if err := mirror.Cast(resp.Result, &r); err == nil {
+				r.Kicked = true
+				c.lock.Lock()
+				c.kickReason = &r
+				c.lock.Unlock()
+			}
+		// 其餘的事件會交給獨立的 goroutine 依序處理，如此一來監聽函式中也能呼叫 `Subscribe` 等需要等待回應的函式，
+		// 而不會卡住讀取回應的迴圈。
+		default:
+			c.dispatch(resp)
+			return nil
+		}
+		if resp.Delivery != "" {
+			c.acknowledge(resp.Delivery)
+		}
+		return nil
 	}
 
 	// 如果回應有編號，取得並確定相對應的請求存在。
+	c.lock.Lock()
 	req, ok := c.requests[resp.ID]
+	c.lock.Unlock()
 	if !ok {
-		return
+		return nil
 	}
 
 	// 將回應傳入給請求中，解除阻塞狀況。
 	req.response <- resp
+	return nil
+}
+
+// dispatch 會將事件放入佇列，並在沒有正在處理事件的 goroutine 時啟動一個。
+func (c *Client) dispatch(resp *Response) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.events = append(c.events, resp)
+	if !c.dispatching {
+		c.dispatching = true
+		go c.dispatchLoop()
+	}
+}
+
+// dispatchLoop 會依照抵達的順序處理佇列中的事件，直到佇列清空為止。
+func (c *Client) dispatchLoop() {
+	for {
+		c.lock.Lock()
+		if len(c.events) == 0 {
+			c.dispatching = false
+			c.lock.Unlock()
+			return
+		}
+		resp := c.events[0]
+		c.events = c.events[1:]
+		c.lock.Unlock()
+		c.handleEvent(resp)
+	}
+}
+
+// handleEvent 會將事件交給相對應的處理函式，並在處理完畢後回報已經收到。
+func (c *Client) handleEvent(resp *Response) {
+	// 已經處理過的投遞表示伺服端沒有收到先前的確認，只需要再次確認而不必重複處理。
+	if resp.Delivery != "" && c.seen(resp.Delivery) {
+		c.acknowledge(resp.Delivery)
+		return
+	}
+	switch resp.Event {
+	// 伺服端送來了頻道共享狀態的完整快照。
+	case "MegoSnapshot":
+		c.snapshotHandler(resp)
+	// 伺服端送來了頻道共享狀態的差異。
+	case "MegoState":
+		c.deltaHandler(resp)
+	default:
+		c.eventHandler(resp)
+	}
+	// 事件處理完畢後才回報已經收到，不論是否有監聽函式都會回報，伺服端在收到回報之前都會保留此事件。
+	if resp.Delivery != "" {
+		c.remember(resp.Delivery)
+		c.acknowledge(resp.Delivery)
+	}
+}
+
+// eventHandler 會將接收到的事件交給相對應的監聽器，並檢查頻道事件的序號是否有所遺漏。
+func (c *Client) eventHandler(resp *Response) {
+	if resp.Sequence > 0 {
+		key := resp.Event + "\x00" + resp.Channel
+		c.lock.Lock()
+		last, ok := c.sequences[key]
+		if resp.Sequence > last {
+			c.sequences[key] = resp.Sequence
+		}
+		c.lock.Unlock()
+		// 序號不連續表示中間有遺漏的事件，向伺服端要求補收兩者之間的事件。
+		if ok && resp.Sequence > last+1 {
+			c.writeMessage(Request{
+				Method: "MegoReplay",
+				Params: []interface{}{resp.Event, resp.Channel, last, resp.Sequence},
+			})
+		}
+	}
+
+	c.lock.Lock()
+	handler, ok := c.listeners[resp.Event]
+	c.lock.Unlock()
+	if !ok {
+		return
+	}
+	data, err := msgpack.Marshal(resp.Result)
+	if err != nil {
+		return
+	}
+	handler(&Event{
+		Data:     data,
+		Channel:  resp.Channel,
+		Sequence: resp.Sequence,
+	})
+}
+
+// seen 會回傳指定的投遞編號是否已經處理過。
+func (c *Client) seen(id string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.delivered[id]
+}
+
+// remember 會記住已經處理過的投遞編號。
+func (c *Client) remember(id string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.delivered == nil {
+		c.delivered = make(map[string]bool)
+	}
+	c.delivered[id] = true
+	c.deliveredOrder = append(c.deliveredOrder, id)
+	if len(c.deliveredOrder) > DeliveryCacheSize {
+		delete(c.delivered, c.deliveredOrder[0])
+		c.deliveredOrder = c.deliveredOrder[1:]
+	}
+}
+
+// acknowledge 會向伺服端回報已經收到指定的投遞。
+func (c *Client) acknowledge(id string) {
+	c.writeMessage(Request{
+		Method: "MegoAck",
+		Params: []interface{}{id},
+	})
+}
+
+// disconnected 會在指定世代的連線中斷時呼叫斷線處理函式，並依照設置自動重新連線。
+func (c *Client) disconnected(generation int, err error) {
+	c.lock.Lock()
+	// 已經被新的連線取代的舊連線就不需要處理。
+	if c.generation != generation {
+		c.lock.Unlock()
+		return
+	}
+	// 連線已經中斷，在重新連線成功之前發送的訊息都會回傳 `ErrClosed`。
+	c.conn = nil
+	reason := c.kickReason
+	closed := c.closed
+	handler := c.onDisconnect
+	c.kickReason = nil
+	c.lock.Unlock()
+
+	if reason == nil {
+		reason = &DisconnectReason{
+			Message: err.Error(),
+		}
+		if v, ok := err.(*websocket.CloseError); ok {
+			reason.Code = v.Code
+			reason.Message = v.Text
+		}
+	}
+	if handler != nil {
+		handler(*reason)
+	}
+	// 被伺服端刻意斷開或由使用者自行關閉的連線不會自動重新連線，但伺服端關閉時仍會嘗試連線，讓客戶端能在伺服端重啟後恢復連線。
+	if (reason.Kicked && reason.Code != CloseShutdown) || closed || !c.Option.AutoReconnect {
+		return
+	}
+	for {
+		<-time.After(c.Option.ReconnectInterval)
+		c.lock.Lock()
+		replaced := c.generation != generation
+		closed := c.closed
+		c.lock.Unlock()
+		// 使用者已經自行重新連線或關閉連線了。
+		if replaced || closed {
+			return
+		}
+		// 連線失敗時世代不會改變，因此會在下一次繼續嘗試。
+		if err := c.Connect(); err == nil {
+			return
+		}
+	}
 }
 
 // Reconnect 會重新連線，能在斷線或結束連線時使用。
+// 重新連線時會沿用相同的客戶端編號與恢復權杖，讓伺服端能在恢復期限內接回原本的階段與訂閱。
 func (c *Client) Reconnect() error {
-	return nil
+	c.lock.Lock()
+	conn := c.conn
+	c.conn = nil
+	// 讓舊連線的斷線處理知道自己已經被取代，不會再自動重新連線。
+	c.generation++
+	c.lock.Unlock()
+	if conn != nil {
+		conn.Close()
+	}
+	return c.Connect()
+}
+
+// OnDisconnect 會設置連線中斷時所呼叫的處理函式，能從傳入的原因得知是否為伺服端刻意斷開（例如：停權、重複登入）。
+func (c *Client) OnDisconnect(handler func(DisconnectReason)) *Client {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.onDisconnect = handler
+	return c
 }
 
 // Close 會結束並關閉連線。
 func (c *Client) Close() error {
-	return c.conn.Close()
+	c.lock.Lock()
+	c.closed = true
+	conn := c.conn
+	c.lock.Unlock()
+	if conn == nil {
+		return ErrClosed
+	}
+	return conn.Close()
+}
+
+// resumeUpload 會等待連線恢復，並向伺服端詢問指定的區塊上傳已經接收了哪些區塊，以及檔案是否已經組合完畢。
+// 超過指定的時間仍無法取得時會回傳最後的錯誤，`0` 表示無上限；連線被使用者自行關閉時則回傳 `ErrClosed`。
+func (c *Client) resumeUpload(id string, timeout time.Duration) ([]int, bool, error) {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	for {
+		resp, err := c.request("MegoUpload").Send([]interface{}{id}).wait()
+		if err == nil {
+			if resp.Error.Code != 0 {
+				return nil, false, resp.Error
+			}
+			var result struct {
+				Received []int
+				Done     bool
+			}
+			if err = mirror.Cast(resp.Result, &result); err == nil {
+				return result.Received, result.Done, nil
+			}
+		}
+		c.lock.Lock()
+		closed := c.closed
+		c.lock.Unlock()
+		if closed {
+			return nil, false, ErrClosed
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return nil, false, err
+		}
+		<-time.After(c.Option.ReconnectInterval)
+	}
 }
 
 // Subscribe 可以訂閱指定的遠端事件，並在之後能透過 `On` 接收。
+// 此函式會等待伺服端的回應，訂閱被拒時會回傳 `ErrSubscriptionRefused`，
+// 如果伺服端有告知其他原因（例如：`StatusFull`）則會回傳帶有狀態碼的 `Error`。
 func (c *Client) Subscribe(event string, channel string) error {
-	return c.writeMessage(Request{
-		Method: "MegoSubscribe",
-		Params: []string{event, channel},
-	})
+	return c.invoke("MegoSubscribe", []interface{}{event, channel}, ErrSubscriptionRefused)
+}
+
+// SubscribeSince 和 `Subscribe` 相同，但會一併向伺服端補收序號大於 `sequence` 的歷史事件。
+// 傳入 `0` 則表示補收伺服端所保留的全部歷史事件。
+func (c *Client) SubscribeSince(event string, channel string, sequence int) error {
+	return c.invoke("MegoSubscribe", []interface{}{event, channel, sequence}, ErrSubscriptionRefused)
 }
 
-// Unsubscribe 會取消訂閱指定的遠端事件，避免接收到無謂的事件。
+// Unsubscribe 會取消訂閱指定的遠端事件，避免接收到無謂的事件。該頻道在本地的共享狀態副本也會一併被移除。
 func (c *Client) Unsubscribe(event string, channel string) error {
-	return c.writeMessage(Request{
-		Method: "MegoUnsubscribe",
-		Params: []string{event, channel},
-	})
+	c.lock.Lock()
+	delete(c.states, event+"\x00"+channel)
+	c.lock.Unlock()
+	return c.invoke("MegoUnsubscribe", []interface{}{event, channel}, ErrSubscriptionRefused)
+}
+
+// Publish 會向遠端指定的事件與頻道廣播資料，所有訂閱該頻道的客戶端（包括自己）都會接收到此事件。
+// 此函式會等待伺服端的回應，發布被拒時會回傳 `ErrPublishRefused` 或帶有狀態碼的 `Error`。
+func (c *Client) Publish(event string, channel string, data interface{}) error {
+	return c.invoke("MegoPublish", []interface{}{event, channel, data, false}, ErrPublishRefused)
+}
+
+// PublishOthers 和 `Publish` 相同，但自己不會接收到此事件。
+func (c *Client) PublishOthers(event string, channel string, data interface{}) error {
+	return c.invoke("MegoPublish", []interface{}{event, channel, data, true}, ErrPublishRefused)
+}
+
+// invoke 會發送 Mego 內建方法的請求，並阻塞直到伺服端回應或逾期。
+// 伺服端以 `StatusNoPermission` 拒絕請求時會回傳傳入的 `refused` 錯誤，較方便比對。
+// 連線中斷或正在重新連線時不會 `panic`，而是回傳 `ErrClosed`。
+func (c *Client) invoke(method string, params []interface{}, refused error) error {
+	resp, err := c.request(method).Send(params).wait()
+	if err != nil {
+		return err
+	}
+	if resp.Error.Code == StatusNoPermission {
+		return refused
+	}
+	if resp.Error.Code != 0 {
+		return resp.Error
+	}
+	return nil
 }
 
 // On 能夠監聽系統或透過 `Subscribe` 所訂閱的事件，並做出相對應的動作。
 func (c *Client) On(event string, handler func(*Event)) *Client {
+	c.lock.Lock()
+	defer c.lock.Unlock()
 	c.listeners[event] = handler
 	return c
 }
@@ -215,6 +626,8 @@ func (c *Client) On(event string, handler func(*Event)) *Client {
 // Off 能移除當初以 `On` 所新增的事件監聽器，但這仍會繼續接收事件資料。
 // 若要停止接收事件資料請使用 `Unsubscribe` 函式。
 func (c *Client) Off(event string) *Client {
+	c.lock.Lock()
+	defer c.lock.Unlock()
 	delete(c.listeners, event)
 	return c
 }
@@ -1,10 +1,13 @@
 package client
 
 import (
+	"errors"
 	"io/ioutil"
 	"os"
 	"testing"
+	"time"
 
+	mego "github.com/TeaMeow/Mego"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -367,6 +370,12 @@ func TestClientSubscribe(t *testing.T) {
 	assert.NoError(err)
 }
 
+func TestClientSubscribeSince(t *testing.T) {
+	assert := assert.New(t)
+	err := client.SubscribeSince("TestEvent", "TestChannel", 0)
+	assert.NoError(err)
+}
+
 func TestClientSubscribeError(t *testing.T) {
 	assert := assert.New(t)
 	err := client.Subscribe("TestRefuseEvent", "TestChannel")
@@ -374,6 +383,21 @@ func TestClientSubscribeError(t *testing.T) {
 	assert.Equal(ErrSubscriptionRefused, err)
 }
 
+func TestClientPublish(t *testing.T) {
+	assert := assert.New(t)
+	err := client.Publish("TestEvent", "TestChannel", H{
+		"Message": "Hello, world!",
+	})
+	assert.NoError(err)
+}
+
+func TestClientPublishError(t *testing.T) {
+	assert := assert.New(t)
+	err := client.Publish("TestRefuseEvent", "TestChannel", nil)
+	assert.Error(err)
+	assert.Equal(ErrPublishRefused, err)
+}
+
 func TestClientOn(t *testing.T) {
 	//assert := assert.New(t)
 	client.On("TestEvent", func(e *Event) {})
@@ -384,8 +408,98 @@ func TestClientOff(t *testing.T) {
 	client.Off("TestEvent")
 }
 
+func TestClientOnState(t *testing.T) {
+	assert := assert.New(t)
+	client.OnState("TestEvent", func(channel string, state map[string]interface{}) {})
+	assert.Nil(client.State("TestEvent", "TestChannel"))
+}
+
 func TestClientUnsubscribe(t *testing.T) {
 	assert := assert.New(t)
 	err := client.Unsubscribe("TestEvent", "TestChannel")
 	assert.NoError(err)
 }
+
+func TestClientAcknowledgeWithoutListener(t *testing.T) {
+	assert := assert.New(t)
+	clock := mego.NewFakeClock(time.Now())
+	e := mego.New()
+	e.Option.Clock = clock
+	var failed bool
+	e.HandleAckFailure(func(s *mego.Session, d mego.Delivery) {
+		failed = true
+	})
+	e.Event("TestAck", mego.EventOption{
+		Acknowledge: true,
+	})
+	go e.Run(":5131")
+	defer e.Close()
+	<-time.After(time.Millisecond * 300)
+
+	c := New("ws://localhost:5131")
+	assert.NoError(c.Connect())
+	assert.NoError(c.Subscribe("TestAck", "TestChannel"))
+
+	// 沒有監聽函式的事件也會被回報收到，伺服端就不會重新傳送。
+	assert.NoError(e.Emit("TestAck", "TestChannel", nil))
+	<-time.After(time.Millisecond * 300)
+	clock.Advance(time.Hour)
+	assert.False(failed)
+}
+
+func TestClientSubscribeInHandler(t *testing.T) {
+	assert := assert.New(t)
+	e := mego.New()
+	e.Event("TestFull", mego.EventOption{
+		MaxSubscribers: 1,
+	})
+	e.HandleSubscribe(func(evt string, ch string, ctx *mego.Context) bool {
+		if ch == "Forbidden" {
+			ctx.RespondWithError(mego.StatusNoPermission, nil, errors.New("custom reason"))
+			return false
+		}
+		return true
+	})
+	go e.Run(":5132")
+	defer e.Close()
+	<-time.After(time.Millisecond * 300)
+
+	c := New("ws://localhost:5132")
+	c.Option.Timeout = time.Second * 2
+	assert.NoError(c.Connect())
+	assert.NoError(c.Subscribe("TestTrigger", "TestChannel"))
+
+	// 監聽函式中也能呼叫需要等待回應的 `Subscribe`。
+	result := make(chan error, 1)
+	c.On("TestTrigger", func(evt *Event) {
+		result <- c.Subscribe("TestOther", "TestChannel")
+	})
+	assert.NoError(e.Emit("TestTrigger", "TestChannel", nil))
+	select {
+	case err := <-result:
+		assert.NoError(err)
+	case <-time.After(time.Second * 3):
+		t.Fatal("handler was not called")
+	}
+
+	// 被拒的訂閱是以狀態碼判斷，而不是錯誤訊息。
+	assert.Equal(ErrSubscriptionRefused, c.Subscribe("TestTrigger", "Forbidden"))
+	other := New("ws://localhost:5132")
+	assert.NoError(other.Connect())
+	assert.NoError(other.Subscribe("TestFull", "TestChannel"))
+	err := c.Subscribe("TestFull", "TestChannel")
+	assert.IsType(Error{}, err)
+	assert.Equal(StatusFull, err.(Error).Code)
+}
+
+func TestClientInvokeDisconnected(t *testing.T) {
+	assert := assert.New(t)
+	c := New("ws://localhost:5133")
+
+	// 尚未連線或斷線時呼叫內建方法會回傳錯誤而不是 `panic`。
+	assert.Equal(ErrClosed, c.Subscribe("Event", "Channel"))
+	assert.Equal(ErrClosed, c.SubscribeSince("Event", "Channel", 0))
+	assert.Equal(ErrClosed, c.Unsubscribe("Event", "Channel"))
+	assert.Equal(ErrClosed, c.Publish("Event", "Channel", nil))
+	assert.Equal(ErrClosed, c.PublishOthers("Event", "Channel", nil))
+}
@@ -0,0 +1,170 @@
+package client
+
+import (
+	"io"
+	"time"
+
+	mirror "github.com/TeaMeow/Mirror"
+)
+
+// From 會讓下載從指定的位元組位置開始，用以接續先前中斷的下載，例如將內容附加至已經下載一部分的檔案。
+func (r *Request) From(offset int64) *Request {
+	r.Range = []int64{offset}
+	return r
+}
+
+// Download 會發送這個請求，並將伺服端以 `RespondFile` 回應的檔案依序寫入至 `w`，檔案會以區塊的方式傳送，因此不需要將整個檔案存放在記憶體中。
+// 下載完畢後會以伺服端附上的摘要檢查內容，不符時回傳 `ErrChecksumMismatch`。下載途中如果斷線或逾期，
+// 會等待連線恢復並從已經寫入的位置繼續下載。設置了 `OnProgress` 時會以檔案名稱、已經下載的位元組數與檔案大小呼叫。
+func (r *Request) Download(w io.Writer) error {
+	if r.err != nil {
+		return r.err
+	}
+	var offset int64
+	if len(r.Range) > 0 {
+		offset = r.Range[0]
+	}
+	var stalls int
+	for {
+		written, retry, err := r.download(w, offset)
+		offset += written
+		if !retry {
+			return err
+		}
+		// 有下載到內容就重新計算重試次數，連續失敗太多次才放棄。
+		if written > 0 {
+			stalls = 0
+		}
+		if stalls++; stalls > r.Option.FileRetries {
+			return err
+		}
+		r.client.lock.Lock()
+		closed := r.client.closed
+		r.client.lock.Unlock()
+		if closed {
+			return ErrClosed
+		}
+		<-time.After(r.client.Option.ReconnectInterval)
+	}
+}
+
+// download 會要求伺服端從指定的位元組位置開始傳送檔案，並回傳此次寫入的位元組數。
+// 斷線、逾期等能夠從已經寫入的位置繼續下載的錯誤會一併回傳 `true`。
+func (r *Request) download(w io.Writer, offset int64) (int64, bool, error) {
+	rng := []int64{offset}
+	if len(r.Range) > 1 {
+		rng = append(rng, r.Range[1])
+	}
+	r.Range = rng
+	// 每次下載都使用新的請求編號與頻道，避免收到上一次下載所遺留的回應。
+	r.client.lock.Lock()
+	r.client.taskID++
+	r.ID = r.client.taskID
+	r.response = make(chan *Response, 1)
+	r.client.requests[r.ID] = r
+	r.client.lock.Unlock()
+	defer func() {
+		r.client.lock.Lock()
+		delete(r.client.requests, r.ID)
+		r.client.lock.Unlock()
+		// 清空頻道，讓讀取迴圈中正要傳入的回應不會被阻塞。
+		select {
+		case <-r.response:
+		default:
+		}
+	}()
+	if err := r.client.writeMessage(*r); err != nil {
+		return 0, true, err
+	}
+
+	var id, name string
+	var total, written int64
+	h := newHash(HashSHA256)
+	for {
+		var resp *Response
+		select {
+		case resp = <-r.response:
+		case <-time.After(r.Option.Timeout):
+			return written, true, ErrTimeout
+		}
+		// 伺服端等不到確認而停止傳送時，能從已經寫入的位置繼續下載。
+		// 帶有下載編號的錯誤必須屬於這次的下載，否則就是先前中斷的下載所遺留的錯誤。
+		if resp.Error.Code != 0 {
+			var v struct {
+				Download string
+			}
+			mirror.Cast(resp.Result, &v)
+			if v.Download != "" && v.Download != id {
+				continue
+			}
+			return written, resp.Error.Code == StatusTimeout, resp.Error
+		}
+
+		switch resp.Event {
+		// 伺服端開始傳送檔案。
+		case "MegoDownload":
+			var v struct {
+				Download string
+				Name     string
+				Size     int64
+			}
+			if err := mirror.Cast(resp.Result, &v); err != nil {
+				return written, false, err
+			}
+			id, name, total = v.Download, v.Name, v.Size
+		// 將區塊寫入並向伺服端確認，讓伺服端繼續傳送下一批區塊。
+		case "MegoDownloadChunk":
+			var v struct {
+				Download string
+				Binary   []byte
+			}
+			if err := mirror.Cast(resp.Result, &v); err != nil {
+				return written, false, err
+			}
+			if v.Download != id {
+				continue
+			}
+			n, err := w.Write(v.Binary)
+			h.Write(v.Binary[:n])
+			written += int64(n)
+			if err != nil {
+				return written, false, err
+			}
+			r.reportDownload(name, offset+written, total)
+			r.client.writeMessage(Request{
+				Method: "MegoDownload",
+				Params: []interface{}{id, offset + written},
+			})
+		// 所有區塊都傳送完畢，以伺服端的摘要檢查這次下載的內容。
+		case "MegoDownloadDone":
+			var v struct {
+				Download string
+				Digest   string
+			}
+			if err := mirror.Cast(resp.Result, &v); err != nil {
+				return written, false, err
+			}
+			if v.Download != id {
+				continue
+			}
+			if v.Digest != sumHash(HashSHA256, h) {
+				return written, false, ErrChecksumMismatch
+			}
+			return written, false, nil
+		// 方法處理函式沒有以 `RespondFile` 回應檔案。
+		default:
+			return written, false, ErrNoDownload
+		}
+	}
+}
+
+// reportDownload 會以已經下載的位元組數呼叫進度處理函式。
+func (r *Request) reportDownload(name string, received int64, total int64) {
+	p := r.progress
+	if p == nil {
+		return
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.handler(name, received, total)
+}
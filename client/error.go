@@ -17,10 +17,16 @@ var (
 	ErrSent = errors.New("mego: use of sent request")
 	// ErrSubscriptionRefused 表示欲訂閱的事件請求被拒。
 	ErrSubscriptionRefused = errors.New("mego: the event subscription was refused")
+	// ErrPublishRefused 表示欲發布的事件請求被拒。
+	ErrPublishRefused = errors.New("mego: the event publishing was refused")
 	// ErrAborted 表示請求已被終止。
 	ErrAborted = errors.New("mego: the request has been aborted")
 	// ErrEmptyRequest 表示欲發送的請求是個 `nil`。
 	ErrEmptyRequest = errors.New("mego: the request is empty")
+	// ErrChecksumMismatch 表示下載的內容與伺服端附上的摘要不符。
+	ErrChecksumMismatch = errors.New("mego: the checksum does not match")
+	// ErrNoDownload 表示伺服端沒有以檔案回應下載請求。
+	ErrNoDownload = errors.New("mego: the response is not a file")
 )
 
 const (
@@ -1,12 +1,18 @@
 package client
 
+import "github.com/vmihailenco/msgpack"
+
 // Event 呈現了一個接收到的事件。
 type Event struct {
 	// Data 是事件所夾帶的資料。基於 Message Pack 格式需要透過 `Bind` 映射到本地建構體。
 	Data []byte
+	// Channel 是廣播此事件的頻道名稱。
+	Channel string
+	// Sequence 是此事件在頻道中的序號，`0` 表示此事件沒有序號。
+	Sequence int
 }
 
 // Bind 能將事件所夾帶的資料映射到本地的建構體。
 func (e *Event) Bind(dest interface{}) error {
-	return nil
+	return msgpack.Unmarshal(e.Data, dest)
 }
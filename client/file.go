@@ -1,6 +1,7 @@
 package client
 
 import (
+	"io"
 	"io/ioutil"
 	"math"
 	"os"
@@ -18,6 +19,16 @@ type File struct {
 	Parts []int `codec:"p" msgpack:"p"`
 	// Name 是檔案的原始名稱。
 	Name string `codec:"n" msgpack:"n"`
+	// Upload 是區塊上傳的編號，斷線重連後伺服端能以此得知要從哪個區塊繼續上傳。
+	Upload string `codec:"u" msgpack:"u"`
+	// Size 是整個檔案的位元組大小，伺服端能以此計算上傳進度。
+	Size int64 `codec:"s" msgpack:"s"`
+	// Offset 是目前區塊在整個檔案中的位元組位置，伺服端會將區塊寫入至此位置，因此區塊能以任意順序抵達。
+	Offset int64 `codec:"o" msgpack:"o"`
+	// Hash 是目前區塊內容的雜湊，伺服端會以此檢查區塊在傳輸途中是否損毀。
+	Hash string `codec:"h" msgpack:"h"`
+	// Digest 是整個檔案的 SHA-256 摘要，伺服端會在檔案接收完畢後檢查。
+	Digest string `codec:"g" msgpack:"g"`
 
 	// source 是這個檔案的源頭，也許是 `string`、`[]byte`、`*os.File`
 	source interface{}
@@ -25,30 +36,35 @@ type File struct {
 	length int
 	// chunkSize 是切割此檔案的區塊長度。
 	chunkSize int
+	// algorithm 是計算區塊雜湊時所使用的演算法，空字串表示不計算。
+	algorithm string
 }
 
-// next 會裝載下一個區塊的內容。
-func (f *File) next() error {
+// chunk 會裝載指定編號（從 `1` 開始）的區塊內容，用於依序上傳或斷線後從指定區塊繼續上傳。
+func (f *File) chunk(n int) error {
 	// 如果這不是區塊檔案則離開。
 	if len(f.Parts) == 0 {
 		return nil
 	}
+	offset := int64(n-1) * int64(f.chunkSize)
+	buf := make([]byte, f.chunkSize)
 
 	switch v := f.source.(type) {
 	// *os.File 表示檔案寫入者。
 	case *os.File:
-		buf := make([]byte, f.chunkSize)
-		_, err := v.ReadAt(buf, int64(f.Parts[0]*f.chunkSize))
-		if err != nil {
+		read, err := v.ReadAt(buf, offset)
+		if err != nil && err != io.EOF {
 			return err
 		}
-		f.Parts[0]++
-		f.Binary = buf
+		buf = buf[:read]
 
 	// 位元組表示檔案二進制內容。
 	case []byte:
-		f.Parts[0]++
-		f.Binary = v[f.Parts[0]*f.chunkSize : f.chunkSize]
+		end := offset + int64(f.chunkSize)
+		if end > int64(len(v)) {
+			end = int64(len(v))
+		}
+		buf = v[offset:end]
 
 	// 字串型態表示檔案路徑。
 	case string:
@@ -56,17 +72,85 @@ func (f *File) next() error {
 		if err != nil {
 			return err
 		}
-		buf := make([]byte, f.chunkSize)
-		_, err = fi.ReadAt(buf, int64(f.Parts[0]*f.chunkSize))
+		defer fi.Close()
+		read, err := fi.ReadAt(buf, offset)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		buf = buf[:read]
+	}
+	f.Parts[1] = n
+	f.Offset = offset
+	f.Binary = buf
+	if f.algorithm != "" {
+		f.Hash = checksum(f.algorithm, buf)
+	}
+	return nil
+}
+
+// digest 會計算整個檔案的 SHA-256 摘要。區塊檔案會以串流方式讀取，避免一次讀取整個檔案的內容。
+func (f *File) digest() error {
+	h := newHash(HashSHA256)
+	if len(f.Parts) == 0 {
+		h.Write(f.Binary)
+		f.Digest = sumHash(HashSHA256, h)
+		return nil
+	}
+	switch v := f.source.(type) {
+	// *os.File 表示檔案寫入者。
+	case *os.File:
+		if _, err := io.Copy(h, io.NewSectionReader(v, 0, int64(f.length))); err != nil {
+			return err
+		}
+
+	// 位元組表示檔案二進制內容。
+	case []byte:
+		h.Write(v)
+
+	// 字串型態表示檔案路徑。
+	case string:
+		fi, err := os.Open(v)
 		if err != nil {
 			return err
 		}
-		f.Parts[0]++
-		f.Binary = buf
+		defer fi.Close()
+		if _, err := io.Copy(h, fi); err != nil {
+			return err
+		}
 	}
+	f.Digest = sumHash(HashSHA256, h)
 	return nil
 }
 
+// part 會回傳一個裝載了指定編號區塊內容的檔案副本，讓多個區塊能同時上傳。
+func (f *File) part(n int) (*File, error) {
+	p := *f
+	p.Parts = []int{f.Parts[0], 0}
+	if err := p.chunk(n); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// partSize 會回傳指定編號區塊的位元組大小，一般檔案則回傳整個檔案的大小。
+func (f *File) partSize(n int) int64 {
+	if len(f.Parts) == 0 {
+		return int64(f.length)
+	}
+	if n == f.Parts[0] {
+		return int64(f.length - (n-1)*f.chunkSize)
+	}
+	return int64(f.chunkSize)
+}
+
+// chunks 會回傳指定長度的檔案需要切割成幾個區塊，空白檔案仍會以一個區塊上傳。
+func (f *File) chunks() int {
+	if f.length == 0 {
+		return 1
+	}
+	return int(math.Ceil(float64(f.length) / float64(f.chunkSize)))
+}
+
 // load 會依照這個檔案的來源去讀取內容並裝載到此檔案建構體。
 // 當檔案要以區塊方式上傳時，這個方法會盡可能地避免讀取整個檔案的內容。
 func (f *File) load(isChunk bool) error {
@@ -79,7 +163,7 @@ func (f *File) load(isChunk bool) error {
 				return err
 			}
 			f.length = int(s.Size())
-			f.Parts = []int{0, int(math.Ceil(float64(f.length) / float64(f.chunkSize)))}
+			f.Parts = []int{f.chunks(), 0}
 			f.Name = filepath.Base(v.Name())
 		} else {
 			b, err := ioutil.ReadAll(v)
@@ -94,7 +178,7 @@ func (f *File) load(isChunk bool) error {
 	case []byte:
 		if isChunk {
 			f.length = len(v)
-			f.Parts = []int{0, int(math.Ceil(float64(f.length) / float64(f.chunkSize)))}
+			f.Parts = []int{f.chunks(), 0}
 		} else {
 			f.Binary = v
 		}
@@ -110,8 +194,9 @@ func (f *File) load(isChunk bool) error {
 			if err != nil {
 				return err
 			}
+			fi.Close()
 			f.length = int(s.Size())
-			f.Parts = []int{0, int(math.Ceil(float64(f.length) / float64(f.chunkSize)))}
+			f.Parts = []int{f.chunks(), 0}
 			f.Name = filepath.Base(v)
 		} else {
 			b, err := ioutil.ReadFile(v)
@@ -122,5 +207,9 @@ func (f *File) load(isChunk bool) error {
 			f.Name = filepath.Base(v)
 		}
 	}
-	return nil
+	if len(f.Parts) == 0 {
+		f.length = len(f.Binary)
+	}
+	f.Size = int64(f.length)
+	return f.digest()
 }
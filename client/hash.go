@@ -0,0 +1,44 @@
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"hash/crc32"
+)
+
+const (
+	// HashCRC32C 是以 Castagnoli 多項式計算的 CRC-32 雜湊，計算速度快，適合用於檢查區塊內容。
+	HashCRC32C = "crc32c"
+	// HashSHA256 是 SHA-256 雜湊，整個檔案的摘要一律使用此演算法。
+	HashSHA256 = "sha256"
+)
+
+// crc32c 是 CRC-32C 所使用的多項式表格。
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// newHash 會依照演算法名稱建立一個雜湊函式，不支援的演算法則回傳 `nil`。
+func newHash(algorithm string) hash.Hash {
+	switch algorithm {
+	case HashCRC32C:
+		return crc32.New(crc32c)
+	case HashSHA256:
+		return sha256.New()
+	}
+	return nil
+}
+
+// sumHash 會將雜湊函式目前的結果以 `演算法:十六進位雜湊` 的格式回傳。
+func sumHash(algorithm string, h hash.Hash) string {
+	return algorithm + ":" + hex.EncodeToString(h.Sum(nil))
+}
+
+// checksum 會以指定演算法計算資料的雜湊，不支援的演算法則回傳空字串。
+func checksum(algorithm string, data []byte) string {
+	h := newHash(algorithm)
+	if h == nil {
+		return ""
+	}
+	h.Write(data)
+	return sumHash(algorithm, h)
+}
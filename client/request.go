@@ -2,9 +2,11 @@ package client
 
 import (
 	"fmt"
+	"sync"
 	"time"
 
 	mirror "github.com/TeaMeow/Mirror"
+	uuid "github.com/satori/go.uuid"
 )
 
 // RequestOption 呈現了一個請求的設置。
@@ -15,6 +17,12 @@ type RequestOption struct {
 	Timeout time.Duration
 	// UploadTimeout 是每個區塊、所有檔案的上傳逾期秒數，`0` 表示無上限，會取代原先的客戶端設置。
 	UploadTimeout time.Duration
+	// ChunkWindow 是區塊上傳時同時傳送中的區塊數量，會取代原先的客戶端設置。
+	ChunkWindow int
+	// ChunkHash 是計算區塊雜湊時所使用的演算法，空字串表示不計算，會取代原先的客戶端設置。
+	ChunkHash string
+	// FileRetries 是檔案或區塊因為內容損毀而被伺服端要求重新上傳時最多重試的次數，會取代原先的客戶端設置。
+	FileRetries int
 }
 
 type Response struct {
@@ -26,6 +34,12 @@ type Response struct {
 	Error Error `codec:"e" msgpack:"e"`
 	// ID 是當時發送此請求的編號，用以讓客戶端比對是哪個請求所造成的回應。
 	ID int `codec:"i" msgpack:"i"`
+	// Channel 是廣播此事件的頻道名稱。
+	Channel string `codec:"c" msgpack:"c"`
+	// Sequence 是此事件在頻道中的遞增序號。
+	Sequence int `codec:"s" msgpack:"s"`
+	// Delivery 是需要確認收到的投遞編號，監聽函式處理完此事件後客戶端會自動以此編號回報。
+	Delivery string `codec:"d" msgpack:"d"`
 }
 
 // Request 呈現了一個籲發送至遠端伺服器的請求。
@@ -38,6 +52,8 @@ type Request struct {
 	Files map[string][]*File `codec:"f" msgpack:"f"`
 	// ID 為本次請求編號，若無則為單次通知廣播不需回應。
 	ID int `codec:"i" msgpack:"i"`
+	// Range 是下載檔案時所要求的位元組範圍，索引 0 為起始位置，索引 1 則是可選的結束位置（不包含）。
+	Range []int64 `codec:"r" msgpack:"r"`
 	// Option 是這個請求的選項設置。
 	Option *RequestOption
 
@@ -49,9 +65,8 @@ type Request struct {
 	fileNameID int
 	// isChunking 表示這個請求是否為區塊上傳。
 	isChunking bool
-	// originalParams 是個可供儲藏原先 `Params` 的地方，這是因為區塊上傳只有在最後一塊才會跟 `Params` 一起上傳。
-	// 因此在那之前我們需要先把 `Params` 藏起來。
-	originalParams interface{}
+	// progress 是此請求的上傳或下載進度，沒有設置進度處理函式時為 `nil`。
+	progress *progress
 	// err 是這個請求建立與執行時所發生的錯誤，會在發送時爆發。
 	err error
 }
@@ -71,14 +86,17 @@ func (r *Request) storeFile(file interface{}, isChunk bool, fieldName ...string)
 		ID:        r.client.fileID,
 		source:    file,
 		chunkSize: r.Option.ChunkSize,
+		algorithm: r.Option.ChunkHash,
 	}
 	// 裝載檔案內容。
 	err := f.load(isChunk)
 	if err != nil {
 		r.err = err
 	}
-	// 讀取下個區塊片段（如果是區塊上傳的話）
-	f.next()
+	// 區塊上傳會有自己的上傳編號，讓伺服端能將各自抵達的區塊組合成同個檔案。
+	if isChunk {
+		f.Upload = uuid.NewV4().String()
+	}
 
 	// 取得檔案欄位名稱，若無指定則自動編號取名。
 	var n string
@@ -114,13 +132,56 @@ func (r *Request) SendFiles(files []interface{}, fieldName ...string) *Request {
 }
 
 // SendFileChunks 會保存稍後將以區塊方式上傳的檔案。
-// 注意：請求使用區塊檔案上傳時，不可使用 `SendFile` 夾帶其他檔案。
+// 一個請求能夾帶多個區塊檔案，也能與 `SendFile` 並用，伺服端會在所有檔案都上傳完畢後才呼叫方法處理函式。
 func (r *Request) SendFileChunks(file interface{}, fieldName ...string) *Request {
 	r.isChunking = true
 	r.storeFile(file, true, fieldName...)
 	return r
 }
 
+// progress 呈現了一個請求的上傳進度。
+type progress struct {
+	// handler 是上傳進度處理函式。
+	handler func(field string, sent int64, total int64)
+	// sent 是每個檔案已經被伺服端接收的區塊編號，用以計算已經傳送的位元組數。
+	sent map[*File]map[int]bool
+	// lock 是讓上傳進度處理函式依序被呼叫的互斥鎖。
+	lock sync.Mutex
+}
+
+// OnProgress 會設置此請求的上傳或下載進度處理函式。區塊檔案每當有區塊被伺服端接收時就會以該檔案已經傳送的位元組數呼叫，
+// 一般檔案則會在請求完成時呼叫一次。以 `Download` 下載檔案時則會在每次接收到區塊時以檔案名稱呼叫。
+// 處理函式會依序被呼叫，請避免在其中執行耗時的工作。
+func (r *Request) OnProgress(handler func(field string, sent int64, total int64)) *Request {
+	r.progress = &progress{
+		handler: handler,
+		sent:    make(map[*File]map[int]bool),
+	}
+	return r
+}
+
+// report 會記錄指定檔案已經被伺服端接收的區塊並呼叫上傳進度處理函式。`reset` 為 `true` 時會以傳入的區塊取代原本的記錄，
+// 用於斷線重連後以伺服端的記錄為準。
+func (r *Request) report(field string, f *File, reset bool, parts ...int) {
+	p := r.progress
+	if p == nil {
+		return
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if reset || p.sent[f] == nil {
+		p.sent[f] = make(map[int]bool)
+	}
+	for _, v := range parts {
+		p.sent[f][v] = true
+	}
+	var sent int64
+	for v := range p.sent[f] {
+		sent += f.partSize(v)
+	}
+	p.handler(field, sent, int64(f.length))
+}
+
 // timeout 會在指定的逾期時間後發送逾期錯誤給自己。
 func (r *Request) timeout() {
 	go func() {
@@ -137,60 +198,269 @@ func (r *Request) timeout() {
 	}()
 }
 
+// wait 會發送這個請求並阻塞直到接收到回應，超過逾期時間則回傳 `ErrTimeout`。
+func (r *Request) wait() (*Response, error) {
+	return r.waitFor(r.Option.Timeout)
+}
+
+// waitFor 會發送這個請求並阻塞直到接收到回應，超過指定的逾期時間則回傳 `ErrTimeout`，`0` 表示無上限。
+func (r *Request) waitFor(timeout time.Duration) (*Response, error) {
+	r.client.lock.Lock()
+	r.client.requests[r.ID] = r
+	r.client.lock.Unlock()
+	defer func() {
+		r.client.lock.Lock()
+		delete(r.client.requests, r.ID)
+		r.client.lock.Unlock()
+	}()
+
+	if err := r.client.writeMessage(*r); err != nil {
+		return nil, err
+	}
+	var expired <-chan time.Time
+	if timeout > 0 {
+		expired = time.After(timeout)
+	}
+	select {
+	case resp := <-r.response:
+		return resp, nil
+	case <-expired:
+		return nil, ErrTimeout
+	}
+}
+
+// chunkTask 是一個等待上傳的區塊。
+type chunkTask struct {
+	// field 是區塊檔案所屬的檔案欄位名稱。
+	field string
+	// file 是區塊所屬的檔案。
+	file *File
+	// part 是區塊編號。
+	part int
+}
+
+// chunkResult 是上傳單一區塊的結果。
+type chunkResult struct {
+	chunkTask
+	// resp 是伺服端對此區塊的回應，沒有送出或沒有收到回應時為 `nil`。
+	resp *Response
+	// err 是斷線或逾期等連線錯誤，這類錯誤會在連線恢復後補傳。
+	err error
+	// fatal 是讀取區塊內容時的錯誤，發生時會直接結束上傳。
+	fatal error
+}
+
+// upload 會上傳請求中所有區塊檔案的區塊，並在所有檔案都組合完畢後將其替換成僅帶有上傳編號的檔案，讓伺服端在最後的請求中認領。
+// 每個區塊都是一個獨立的請求，所有檔案的區塊會共用 `ChunkWindow` 個同時傳送的名額。
+// 上傳途中如果斷線或逾期，會等待連線恢復並向伺服端詢問已經接收的區塊，接著補傳遺漏的區塊。
+func (r *Request) upload() error {
+	received := make(map[*File]map[int]bool)
+	done := make(map[*File]bool)
+	retries := make(map[chunkTask]int)
+	var stalls int
+
+	for {
+		// 找出所有尚未組合完畢的檔案中，尚未被伺服端接收的區塊。
+		var tasks []chunkTask
+		pending := make(map[*File]string)
+		for field, v := range r.Files {
+			for _, f := range v {
+				if len(f.Parts) == 0 || done[f] {
+					continue
+				}
+				pending[f] = field
+				if received[f] == nil {
+					received[f] = make(map[int]bool)
+				}
+				for i := 1; i <= f.Parts[0]; i++ {
+					if !received[f][i] {
+						tasks = append(tasks, chunkTask{field, f, i})
+					}
+				}
+			}
+		}
+		if len(pending) == 0 {
+			break
+		}
+
+		var lost, retry bool
+		for _, v := range r.sendChunks(tasks) {
+			switch {
+			case v.fatal != nil:
+				return v.fatal
+			// 尚未送出或沒有收到回應的區塊會在連線恢復後補傳。
+			case v.resp == nil:
+				lost = lost || v.err != nil
+			// 區塊在傳輸途中損毀，下一輪會重新傳送相同的區塊。
+			case v.resp.Error.Code == StatusFileRetry:
+				if retries[v.chunkTask]++; retries[v.chunkTask] > r.Option.FileRetries {
+					return v.resp.Error
+				}
+				retry = true
+			case v.resp.Event == "MegoChunkNext":
+				received[v.file][v.part] = true
+			case v.resp.Event == "MegoChunkDone":
+				received[v.file][v.part] = true
+				done[v.file] = true
+			// 伺服端不打算處理本檔案了。
+			case v.resp.Event == "MegoChunkAbort":
+				return ErrAborted
+			case v.resp.Error.Code != 0:
+				return v.resp.Error
+			}
+		}
+		if !lost && retry {
+			continue
+		}
+		// 所有區塊都被接收了卻仍有檔案沒有完成（例如伺服端因為摘要不符而重新開始），或是連線有問題時，
+		// 就等待連線恢復，並以伺服端所記錄的已接收區塊為準。
+		if !lost {
+			var incomplete bool
+			for f := range pending {
+				incomplete = incomplete || !done[f]
+			}
+			if !incomplete {
+				continue
+			}
+			if stalls++; stalls > r.Option.FileRetries {
+				return ErrAborted
+			}
+		}
+		for f, field := range pending {
+			if done[f] {
+				continue
+			}
+			parts, finished, err := r.client.resumeUpload(f.Upload, r.Option.UploadTimeout)
+			if err != nil {
+				return err
+			}
+			received[f] = make(map[int]bool)
+			for _, v := range parts {
+				received[f][v] = true
+			}
+			done[f] = finished
+			r.report(field, f, true, parts...)
+		}
+	}
+
+	// 將區塊檔案替換成僅帶有上傳編號的檔案，伺服端會以此認領已經組合完畢的檔案。
+	for field, v := range r.Files {
+		for i, f := range v {
+			if len(f.Parts) > 0 {
+				r.Files[field][i] = &File{
+					ID:     f.ID,
+					Name:   f.Name,
+					Upload: f.Upload,
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// sendChunks 會以多個同時進行的請求上傳指定的區塊。發生連線錯誤或讀取錯誤時，其餘的區塊就不會再被送出。
+func (r *Request) sendChunks(tasks []chunkTask) []chunkResult {
+	window := r.Option.ChunkWindow
+	if window < 1 {
+		window = 1
+	}
+	results := make([]chunkResult, len(tasks))
+	queue := make(chan int)
+	var stopped bool
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < window; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				res := &results[i]
+				res.chunkTask = tasks[i]
+				lock.Lock()
+				stop := stopped
+				lock.Unlock()
+				if stop {
+					continue
+				}
+				f, err := res.file.part(res.part)
+				if err != nil {
+					res.fatal = err
+				} else {
+					res.resp, res.err = r.sendChunk(res.field, f)
+				}
+				// 區塊被伺服端接收時回報上傳進度。
+				if res.resp != nil && (res.resp.Event == "MegoChunkNext" || res.resp.Event == "MegoChunkDone") {
+					r.report(res.field, res.file, false, res.part)
+				}
+				// 連線有問題，或是上傳被終止時就不再送出其他區塊。
+				if res.fatal != nil || res.err != nil || res.resp.Event == "MegoChunkAbort" {
+					lock.Lock()
+					stopped = true
+					lock.Unlock()
+				}
+			}
+		}()
+	}
+	for i := range tasks {
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+	return results
+}
+
+// sendChunk 會以一個獨立的請求上傳已經裝載好的區塊並等待回應，區塊請求不會夾帶請求的資料。
+// 連線中斷時會回傳 `ErrClosed`，讓上傳流程在連線恢復後補傳。
+func (r *Request) sendChunk(field string, f *File) (*Response, error) {
+	req := r.client.request(r.Method)
+	req.Option = r.Option
+	req.Files[field] = []*File{f}
+	return req.waitFor(r.Option.UploadTimeout)
+}
+
 // End 結束並發送這個請求且不求回應。
 func (r *Request) End() error {
 	return r.EndStruct(nil)
 }
 
 // EndStruct 結束並發送這個請求，且將回應映射到本地建構體上。
+// 夾帶區塊檔案時會先上傳所有的區塊，並在所有檔案都組合完畢後才發送請求。
 func (r *Request) EndStruct(dest interface{}) error {
 	if r.err != nil {
 		return r.err
 	}
 
-	var chunk *File
-	// 如果本請求是區塊上傳，那麼先暫時把資料藏在緩衝區。
-	// 只發送區塊檔案，直至最後一個區塊才將資料從緩衝區取出並一同上傳。
+	// 先上傳所有的區塊檔案，再以最後的請求夾帶資料、一般檔案並認領組合完畢的區塊檔案。
 	if r.isChunking {
-		for _, v := range r.Files {
-			chunk = v[0]
-		}
-		// 如果這是第一個區塊，而且不是最後一塊。
-		if chunk.Parts[0] == 0 && chunk.Parts[0] > chunk.Parts[1] {
-			r.originalParams = r.Params
-			r.Params = []byte{}
+		if err := r.upload(); err != nil {
+			return err
 		}
-		// 如果這是最後一塊。
-		if chunk.Parts[0] == chunk.Parts[1] {
-			r.Params = r.originalParams
-			r.originalParams = []byte{}
+	}
+	var resp *Response
+	var err error
+	// 檔案在傳輸途中損毀時會重新發送整個請求。
+	for retries := 0; ; retries++ {
+		resp, err = r.wait()
+		if err != nil || resp.Error.Code != StatusFileRetry || retries >= r.Option.FileRetries {
+			break
 		}
 	}
-
-	// 將此請求保存至客戶端的請求切片中，之後才能在其他函式取得此請求。
-	r.client.requests[r.ID] = r
-
-	// 向伺服端發送請求。
-	err := r.client.writeMessage(*r)
 	if err != nil {
 		return err
 	}
-	// 啟動逾時檢查。
-	//r.timeout()
-	// 阻塞並等待此請求的回應。
-	resp := <-r.response
-
-	// 如果回應有事件名稱則依照相對應方法處理。
-	switch resp.Event {
-	case "MegoChunkNext":
-		// 在區塊中載入下一段內容。
-		chunk.next()
-		// 呼叫自己重新發送相同的內容。
-		if err := r.End(); err != nil {
-			return err
+	// 一般檔案隨著請求一同送出，因此在請求完成時回報上傳進度。
+	if resp.Error.Code == 0 {
+		for field, v := range r.Files {
+			for _, f := range v {
+				if f.Upload == "" {
+					r.report(field, f, false, 1)
+				}
+			}
 		}
-		return nil
-	case "MegoChunkAbort":
+	}
+
+	// 伺服端不打算處理本檔案了。
+	if resp.Event == "MegoChunkAbort" {
 		return ErrAborted
 	}
 
@@ -0,0 +1,133 @@
+package client
+
+import mirror "github.com/TeaMeow/Mirror"
+
+// StateHandler 是頻道共享狀態更新時所呼叫的處理函式。
+type StateHandler func(channel string, state map[string]interface{})
+
+// state 是伺服端頻道共享狀態在本地的副本。
+type state struct {
+	// version 是此副本目前的版本。
+	version int
+	// data 是共享狀態的內容。
+	data map[string]interface{}
+}
+
+// State 會回傳指定事件與頻道在本地的共享狀態副本，尚未收到狀態時回傳 `nil`。
+func (c *Client) State(event string, channel string) map[string]interface{} {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	s, ok := c.states[event+"\x00"+channel]
+	if !ok {
+		return nil
+	}
+	return copyState(s.data)
+}
+
+// OnState 會設置指定事件的共享狀態處理函式，每當任一頻道的本地副本因快照或差異而更新時就會被呼叫。
+func (c *Client) OnState(event string, handler StateHandler) *Client {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.stateListeners == nil {
+		c.stateListeners = make(map[string]StateHandler)
+	}
+	c.stateListeners[event] = handler
+	return c
+}
+
+// snapshotHandler 會以伺服端送來的完整快照取代本地的共享狀態副本。
+func (c *Client) snapshotHandler(resp *Response) {
+	var r struct {
+		Event   string
+		Version int
+		State   map[string]interface{}
+	}
+	if err := mirror.Cast(resp.Result, &r); err != nil {
+		return
+	}
+	if r.State == nil {
+		r.State = make(map[string]interface{})
+	}
+	c.lock.Lock()
+	if c.states == nil {
+		c.states = make(map[string]*state)
+	}
+	c.states[r.Event+"\x00"+resp.Channel] = &state{
+		version: r.Version,
+		data:    r.State,
+	}
+	c.lock.Unlock()
+
+	c.stateChanged(r.Event, resp.Channel)
+}
+
+// deltaHandler 會將伺服端送來的差異套用至本地的共享狀態副本。
+// 當版本不連續時表示中間有遺漏的差異，會向伺服端要求完整的快照。
+func (c *Client) deltaHandler(resp *Response) {
+	var r struct {
+		Event   string
+		Version int
+		Patch   map[string]interface{}
+	}
+	if err := mirror.Cast(resp.Result, &r); err != nil {
+		return
+	}
+	key := r.Event + "\x00" + resp.Channel
+	c.lock.Lock()
+	s, ok := c.states[key]
+	// 第一個版本的差異就是完整的狀態，不需要另外要求快照。
+	if !ok && r.Version == 1 {
+		if c.states == nil {
+			c.states = make(map[string]*state)
+		}
+		s = &state{
+			data: make(map[string]interface{}),
+		}
+		c.states[key] = s
+		ok = true
+	}
+	switch {
+	// 比本地副本還舊的差異已經包含在副本中了。
+	case ok && r.Version <= s.version:
+		c.lock.Unlock()
+		return
+	case !ok || r.Version != s.version+1:
+		c.lock.Unlock()
+		c.writeMessage(Request{
+			Method: "MegoState",
+			Params: []interface{}{r.Event, resp.Channel},
+		})
+		return
+	}
+	for k, v := range r.Patch {
+		if v == nil {
+			delete(s.data, k)
+		} else {
+			s.data[k] = v
+		}
+	}
+	s.version = r.Version
+	c.lock.Unlock()
+
+	c.stateChanged(r.Event, resp.Channel)
+}
+
+// stateChanged 會將更新後的共享狀態副本傳入給該事件的處理函式。
+func (c *Client) stateChanged(event string, channel string) {
+	c.lock.Lock()
+	handler, ok := c.stateListeners[event]
+	c.lock.Unlock()
+	if !ok {
+		return
+	}
+	handler(channel, c.State(event, channel))
+}
+
+// copyState 會複製一份共享狀態，避免外部修改本地副本。
+func copyState(data map[string]interface{}) map[string]interface{} {
+	cp := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		cp[k] = v
+	}
+	return cp
+}
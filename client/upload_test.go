@@ -0,0 +1,59 @@
+package client
+
+import (
+	"bytes"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	mego "github.com/TeaMeow/Mego"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientUploadReconnect(t *testing.T) {
+	assert := assert.New(t)
+	e := mego.New()
+	e.Option.FileStore = mego.NewMemoryFileStore()
+	e.Register("Upload", func(c *mego.Context) {
+		r, err := c.MustGetFile().Open()
+		if err != nil {
+			panic(err)
+		}
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			panic(err)
+		}
+		c.Respond(b)
+	})
+	go e.Run(":5130")
+	defer e.Close()
+	<-time.After(time.Millisecond * 300)
+
+	c := New("ws://localhost:5130")
+	c.Option.ChunkSize = 16
+	c.Option.ChunkWindow = 2
+	c.Option.UploadTimeout = time.Millisecond * 500
+	c.Option.AutoReconnect = true
+	c.Option.ReconnectInterval = time.Millisecond * 100
+	assert.NoError(c.Connect())
+
+	// 在第一個區塊被接收後中斷連線，剩餘的區塊應該在重新連線後補傳。
+	var once sync.Once
+	data := bytes.Repeat([]byte("0123456789abcdef"), 20)
+	var resp []byte
+	err := c.
+		Call("Upload").
+		SendFileChunks(data).
+		OnProgress(func(field string, sent int64, total int64) {
+			once.Do(func() {
+				c.lock.Lock()
+				conn := c.conn
+				c.lock.Unlock()
+				conn.Close()
+			})
+		}).
+		EndStruct(&resp)
+	assert.NoError(err)
+	assert.Equal(data, resp)
+}
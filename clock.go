@@ -0,0 +1,133 @@
+package mego
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock 是引擎取得時間與建立計時器的介面，排程與重新傳送等功能都會透過此介面計時。
+// 測試時能以 `FakeClock` 取代，手動推進時間而不必真的等待。
+type Clock interface {
+	// Now 會回傳目前的時間。
+	Now() time.Time
+	// AfterFunc 會在經過指定時間後於另一個 Goroutine 中呼叫傳入的函式。
+	AfterFunc(d time.Duration, fn func()) Timer
+}
+
+// Timer 是由 `Clock` 所建立的計時器。
+type Timer interface {
+	// Stop 會停止計時器，如果計時器已經觸發或已經被停止則回傳 `false`。
+	Stop() bool
+}
+
+// realClock 是以系統時間計時的時鐘。
+type realClock struct{}
+
+// Now 會回傳目前的系統時間。
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// AfterFunc 會透過 `time.AfterFunc` 建立計時器。
+func (realClock) AfterFunc(d time.Duration, fn func()) Timer {
+	return time.AfterFunc(d, fn)
+}
+
+// clock 會回傳引擎所使用的時鐘，未指定時使用系統時間。
+func (e *Engine) clock() Clock {
+	if e.Option.Clock == nil {
+		return realClock{}
+	}
+	return e.Option.Clock
+}
+
+// NewFakeClock 會建立一個停在指定時間的假時鐘，時間只會在呼叫 `Advance` 時前進。
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{
+		now: now,
+	}
+}
+
+// FakeClock 是供測試使用的假時鐘，計時器只會在呼叫 `Advance` 推進時間時觸發。
+type FakeClock struct {
+	// now 是假時鐘目前的時間。
+	now time.Time
+	// timers 是尚未觸發的計時器。
+	timers []*fakeTimer
+	// lock 是保護時間與計時器的互斥鎖。
+	lock sync.Mutex
+}
+
+// fakeTimer 是由假時鐘所建立的計時器。
+type fakeTimer struct {
+	// clock 是建立此計時器的假時鐘。
+	clock *FakeClock
+	// when 是此計時器應該觸發的時間。
+	when time.Time
+	// fn 是觸發時所呼叫的函式。
+	fn func()
+}
+
+// Now 會回傳假時鐘目前的時間。
+func (f *FakeClock) Now() time.Time {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.now
+}
+
+// AfterFunc 會建立一個在假時鐘經過指定時間後觸發的計時器。
+func (f *FakeClock) AfterFunc(d time.Duration, fn func()) Timer {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	t := &fakeTimer{
+		clock: f,
+		when:  f.now.Add(d),
+		fn:    fn,
+	}
+	f.timers = append(f.timers, t)
+	return t
+}
+
+// Advance 會將假時鐘推進指定的時間，並依時間順序同步呼叫期間內所有應該觸發的計時器。
+// 計時器在觸發時所建立的新計時器，若也在期間內則同樣會被觸發。
+func (f *FakeClock) Advance(d time.Duration) {
+	f.lock.Lock()
+	target := f.now.Add(d)
+	for {
+		// 找出最早應該觸發的計時器。
+		next := -1
+		for i, v := range f.timers {
+			if !v.when.After(target) && (next == -1 || v.when.Before(f.timers[next].when)) {
+				next = i
+			}
+		}
+		if next == -1 {
+			break
+		}
+		t := f.timers[next]
+		f.timers = append(f.timers[:next], f.timers[next+1:]...)
+		if t.when.After(f.now) {
+			f.now = t.when
+		}
+		// 呼叫函式時不能持有鎖，否則函式中無法再建立計時器。
+		f.lock.Unlock()
+		t.fn()
+		f.lock.Lock()
+	}
+	f.now = target
+	f.lock.Unlock()
+}
+
+// Stop 會將此計時器從假時鐘中移除。
+func (t *fakeTimer) Stop() bool {
+	f := t.clock
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for i, v := range f.timers {
+		if v == t {
+			f.timers = append(f.timers[:i], f.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
@@ -24,6 +24,8 @@ type Context struct {
 	Session *Session
 	// isAborted 表示了這個請求是不是以已經被終止了。
 	isAborted bool
+	// responded 表示這個請求是否已經回應過客戶端。
+	responded bool
 	// ID 是本次請求的工作編號，用以讓客戶端呼叫相對應的處理函式。
 	ID int
 	// Request 是這個 WebSocket 的 HTTP 請求建構體。
@@ -39,6 +41,8 @@ type Context struct {
 	handlers []HandlerFunc
 	// params 存放著已解序的參數陣列。
 	params []interface{}
+	// rng 是客戶端所要求的下載位元組範圍。
+	rng []int64
 	// files 為檔案欄位切片，用以存放使用者上傳後且已解析的檔案。
 	files map[string][]*File
 	// engine 是主要引擎。
@@ -75,8 +79,16 @@ func (c *Context) ClientIP() string {
 }
 
 // Subscribe 能將此客戶納入指定事件、頻道的監聽清單中，方能接收其事件。
-func (c *Context) Subscribe(event string, channel string) *Context {
-	c.engine.subscribe(c.Session, event, channel)
+// 可以額外傳入此客戶在頻道中的在線資料，其他訂閱者能透過 `Members` 或 `MegoJoin` 事件取得。
+// 頻道已滿等無法訂閱的情況會被記錄於 `Errors` 中。
+func (c *Context) Subscribe(event string, channel string, presence ...interface{}) *Context {
+	var p interface{}
+	if len(presence) > 0 {
+		p = presence[0]
+	}
+	if err := c.engine.subscribe(c.Session, event, channel, p, false); err != nil {
+		c.Error(err)
+	}
 	return c
 }
 
@@ -86,6 +98,11 @@ func (c *Context) Unsubscribe(event string, channel string) *Context {
 	return c
 }
 
+// hasParam 會回傳客戶端是否有傳入指定索引的參數，用以區分可選參數是未傳入還是傳入了零值。
+func (c *Context) hasParam(index int) bool {
+	return index >= 0 && index < c.Param(index).Len()
+}
+
 // Param 能夠從參數陣列中透過指定索引取得特定的參數。
 func (c *Context) Param(indexes ...int) *Param {
 	// 預設索引為 `0` 省去使用者指定的困擾。
@@ -149,6 +166,7 @@ func (c *Context) AbortWithError(code int, data interface{}, err error) {
 
 // Respond 會以指定的狀態碼、資料回應特定的客戶端。
 func (c *Context) Respond(result interface{}) {
+	c.responded = true
 	c.Session.write(Response{
 		Result: result,
 		ID:     c.ID,
@@ -157,6 +175,7 @@ func (c *Context) Respond(result interface{}) {
 
 // RespondWithError 會以指定的狀態碼、錯誤資料與訊息回應特定的客戶端並表示錯誤發生。
 func (c *Context) RespondWithError(code int, data interface{}, err error) {
+	c.responded = true
 	c.Session.write(Response{
 		Error: ResponseError{
 			Code:    code,
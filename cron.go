@@ -0,0 +1,176 @@
+package mego
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// schedule 是週期性排程的時間規則。
+type schedule interface {
+	// next 會回傳晚於指定時間的下一個觸發時間。
+	next(t time.Time) time.Time
+}
+
+// intervalSchedule 是以固定間隔觸發的排程，由 `@every` 描述。
+type intervalSchedule struct {
+	// interval 是每次觸發的間隔。
+	interval time.Duration
+}
+
+// next 會回傳指定時間經過一個間隔後的時間。
+func (s intervalSchedule) next(t time.Time) time.Time {
+	return t.Add(s.interval)
+}
+
+// cronSchedule 是以 Cron 格式描述的排程，每個欄位都以位元表示允許的數值。
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// domStar 與 dowStar 表示日期與星期欄位是否以 `*` 開頭（包括 `*/n`），兩者皆有限制時只要符合其一即可觸發。
+	domStar, dowStar bool
+}
+
+// cronField 是 Cron 欄位的數值範圍。
+type cronField struct {
+	min, max int
+}
+
+var (
+	// cronFields 依序是分鐘、小時、日期、月份、星期欄位的數值範圍。
+	cronFields = []cronField{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 6}}
+	// cronDescriptors 是常用排程的簡寫。
+	cronDescriptors = map[string]string{
+		"@yearly":   "0 0 1 1 *",
+		"@annually": "0 0 1 1 *",
+		"@monthly":  "0 0 1 * *",
+		"@weekly":   "0 0 * * 0",
+		"@daily":    "0 0 * * *",
+		"@midnight": "0 0 * * *",
+		"@hourly":   "0 * * * *",
+	}
+)
+
+// parseSchedule 會解析排程規則。規則能是以空白分隔的五個 Cron 欄位（分鐘、小時、日期、月份、星期），
+// 每個欄位能使用 `*`、`a-b`、`a,b`、`*/n`、`a-b/n` 與 `a/n` 等格式，其中 `a/n` 表示從 `a` 開始每隔 `n` 直到欄位的最大值；也能是 `@hourly` 等簡寫，或是 `@every 10s` 這樣的固定間隔。
+func parseSchedule(spec string) (schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, "@every ") {
+		d, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(spec, "@every ")))
+		if err != nil || d <= 0 {
+			return nil, ErrInvalidSchedule
+		}
+		return intervalSchedule{d}, nil
+	}
+	if v, ok := cronDescriptors[spec]; ok {
+		spec = v
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != len(cronFields) {
+		return nil, ErrInvalidSchedule
+	}
+	bits := make([]uint64, len(fields))
+	for i, v := range fields {
+		b, err := parseCronField(v, cronFields[i])
+		if err != nil {
+			return nil, err
+		}
+		bits[i] = b
+	}
+	return &cronSchedule{
+		minute:  bits[0],
+		hour:    bits[1],
+		dom:     bits[2],
+		month:   bits[3],
+		dow:     bits[4],
+		domStar: strings.HasPrefix(fields[2], "*"),
+		dowStar: strings.HasPrefix(fields[4], "*"),
+	}, nil
+}
+
+// parseCronField 會將單一 Cron 欄位解析成以位元表示的允許數值。
+func parseCronField(field string, r cronField) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		var stepped bool
+		if i := strings.Index(part, "/"); i != -1 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, ErrInvalidSchedule
+			}
+			step = n
+			stepped = true
+			part = part[:i]
+		}
+		min, max := r.min, r.max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			a, err1 := strconv.Atoi(bounds[0])
+			b, err2 := strconv.Atoi(bounds[1])
+			if err1 != nil || err2 != nil {
+				return 0, ErrInvalidSchedule
+			}
+			min, max = a, b
+		default:
+			n, err := strconv.Atoi(part)
+			if err != nil {
+				return 0, ErrInvalidSchedule
+			}
+			min, max = n, n
+			// `a/n` 表示從 `a` 開始直到欄位的最大值。
+			if stepped {
+				max = r.max
+			}
+		}
+		if min < r.min || max > r.max || min > max {
+			return 0, ErrInvalidSchedule
+		}
+		for n := min; n <= max; n += step {
+			bits |= 1 << uint(n)
+		}
+	}
+	return bits, nil
+}
+
+// has 會回傳位元中是否包含指定的數值。
+func has(bits uint64, n int) bool {
+	return bits&(1<<uint(n)) != 0
+}
+
+// next 會回傳晚於指定時間的下一個符合規則的時間，最多往後找五年，找不到時回傳零值。
+func (s *cronSchedule) next(t time.Time) time.Time {
+	t = t.Add(time.Minute - time.Duration(t.Second())*time.Second - time.Duration(t.Nanosecond()))
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if !has(s.month, int(t.Month())) {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.day(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !has(s.hour, t.Hour()) {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if !has(s.minute, t.Minute()) {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// day 會回傳指定日期是否符合日期與星期欄位。
+func (s *cronSchedule) day(t time.Time) bool {
+	dom := has(s.dom, t.Day())
+	dow := has(s.dow, int(t.Weekday()))
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
@@ -0,0 +1,58 @@
+package mego
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		spec   string
+		expect schedule
+	}{
+		{"@every 10s", intervalSchedule{10 * time.Second}},
+		{"* * * * *", &cronSchedule{1<<60 - 1, 1<<24 - 1, 1<<32 - 2, 1<<13 - 2, 1<<7 - 1, true, true}},
+		{"@hourly", &cronSchedule{1, 1<<24 - 1, 1<<32 - 2, 1<<13 - 2, 1<<7 - 1, true, true}},
+		{"0,30 9-11 1 */6 1-5/2", &cronSchedule{1 | 1<<30, 1<<9 | 1<<10 | 1<<11, 1 << 1, 1<<1 | 1<<7, 1<<1 | 1<<3 | 1<<5, false, false}},
+		{"50/5 0 */10 * *", &cronSchedule{1<<50 | 1<<55, 1, 1<<1 | 1<<11 | 1<<21 | 1<<31, 1<<13 - 2, 1<<7 - 1, true, true}},
+		{"0 0 1 1 5/1", &cronSchedule{1, 1, 1 << 1, 1 << 1, 1<<5 | 1<<6, false, false}},
+	}
+	for _, v := range tests {
+		s, err := parseSchedule(v.spec)
+		assert.NoError(t, err, v.spec)
+		assert.Equal(t, v.expect, s, v.spec)
+	}
+
+	for _, v := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 7", "5-1 * * * *", "*/0 * * * *", "a * * * *", "@every", "@every -1s", "@weekday"} {
+		_, err := parseSchedule(v)
+		assert.Equal(t, ErrInvalidSchedule, err, v)
+	}
+}
+
+func TestCronScheduleNext(t *testing.T) {
+	tests := []struct {
+		spec   string
+		from   time.Time
+		expect time.Time
+	}{
+		{"* * * * *", time.Date(2020, 1, 1, 0, 0, 30, 0, time.UTC), time.Date(2020, 1, 1, 0, 1, 0, 0, time.UTC)},
+		{"*/5 * * * *", time.Date(2020, 1, 1, 0, 5, 0, 0, time.UTC), time.Date(2020, 1, 1, 0, 10, 0, 0, time.UTC)},
+		{"10/20 * * * *", time.Date(2020, 1, 1, 0, 31, 0, 0, time.UTC), time.Date(2020, 1, 1, 0, 50, 0, 0, time.UTC)},
+		{"0 9 * * 1-5", time.Date(2020, 1, 4, 10, 0, 0, 0, time.UTC), time.Date(2020, 1, 6, 9, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2020, 1, 31, 23, 59, 0, 0, time.UTC), time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// 日期與星期都有限制時只要符合其一即可觸發。
+		{"0 0 15 * 1", time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2020, 1, 6, 0, 0, 0, 0, time.UTC)},
+		// 以 `*` 開頭的 `*/n` 不算是有限制，因此兩者都必須符合。
+		{"0 0 */2 * 1", time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2020, 1, 13, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 * */3", time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC), time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), time.Time{}},
+	}
+	for _, v := range tests {
+		s, err := parseSchedule(v.spec)
+		assert.NoError(t, err, v.spec)
+		assert.Equal(t, v.expect, s.next(v.from), v.spec)
+	}
+}
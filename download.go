@@ -0,0 +1,236 @@
+package mego
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+var (
+	// DefaultDownloadChunkSize 是檔案下載時預設每個區塊的位元組大小。
+	DefaultDownloadChunkSize = 256 * KB
+	// DefaultDownloadWindow 是檔案下載時預設最多有幾個尚未被客戶端確認的區塊。
+	DefaultDownloadWindow = 4
+	// DefaultDownloadTimeout 是檔案下載時預設等待客戶端確認的秒數。
+	DefaultDownloadTimeout = 30
+)
+
+// download 呈現了一個正在傳送給客戶端的檔案下載。
+type download struct {
+	// id 是此下載的編號。
+	id string
+	// session 是下載此檔案的階段編號，其他階段無法確認此下載。
+	session string
+	// received 是客戶端回報已經接收的位元組位置。
+	received int64
+	// signal 會在客戶端回報時通知傳送中的下載繼續傳送。
+	signal chan struct{}
+	// lock 是保護已接收位置的互斥鎖。
+	lock sync.Mutex
+}
+
+// position 會回傳客戶端回報已經接收的位元組位置。
+func (d *download) position() int64 {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.received
+}
+
+// downloadChunkSize 會回傳檔案下載時每個區塊的位元組大小。
+func (e *Engine) downloadChunkSize() int {
+	if e.Option.DownloadChunkSize == 0 {
+		return DefaultDownloadChunkSize
+	}
+	return e.Option.DownloadChunkSize
+}
+
+// downloadWindow 會回傳檔案下載時最多有幾個尚未被客戶端確認的區塊。
+func (e *Engine) downloadWindow() int {
+	if e.Option.DownloadWindow == 0 {
+		return DefaultDownloadWindow
+	}
+	return e.Option.DownloadWindow
+}
+
+// downloadTimeout 會回傳檔案下載時等待客戶端確認的時間。
+func (e *Engine) downloadTimeout() time.Duration {
+	if e.Option.DownloadTimeout == 0 {
+		return time.Second * time.Duration(DefaultDownloadTimeout)
+	}
+	return time.Second * time.Duration(e.Option.DownloadTimeout)
+}
+
+// RespondFile 會以區塊的方式將檔案串流給客戶端，而不是將整個檔案放在單一個回應中。客戶端每接收一定數量的區塊就必須確認一次，
+// 因此緩慢的客戶端不會讓伺服端累積過多的訊息，所有區塊傳送完畢後會附上這段內容的 SHA-256 摘要供客戶端檢查。
+// 客戶端能在請求中要求特定的位元組範圍，用以接續中斷的下載。檔案會在另一個 Goroutine 中傳送，
+// 因此請勿在處理函式中自行關閉 `r`，如果 `r` 實作了 `io.Closer` 則會在傳送完畢後被自動關閉。
+func (c *Context) RespondFile(name string, r io.ReadSeeker) error {
+	e := c.engine
+	// fail 會在無法開始傳送時回應客戶端並關閉檔案。
+	fail := func(code int, err error) error {
+		c.RespondWithError(code, nil, err)
+		closeReader(r)
+		return err
+	}
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fail(StatusError, err)
+	}
+	// 索引 0 為起始位置，索引 1 則是可選的結束位置（不包含）。
+	start, end := int64(0), size
+	if len(c.rng) > 0 {
+		start = c.rng[0]
+	}
+	if len(c.rng) > 1 && c.rng[1] > 0 {
+		end = c.rng[1]
+	}
+	if start < 0 || start > end || end > size {
+		return fail(StatusInvalid, ErrInvalidRange)
+	}
+	if _, err := r.Seek(start, io.SeekStart); err != nil {
+		return fail(StatusError, err)
+	}
+
+	d := &download{
+		id:       uuid.NewV4().String(),
+		session:  c.Session.ID,
+		received: start,
+		signal:   make(chan struct{}, 1),
+	}
+	e.lock.Lock()
+	if e.downloads == nil {
+		e.downloads = make(map[string]*download)
+	}
+	e.downloads[d.id] = d
+	e.lock.Unlock()
+
+	c.responded = true
+	c.Session.write(Response{
+		Event: "MegoDownload",
+		ID:    c.ID,
+		Result: H{
+			"Download": d.id,
+			"Name":     name,
+			"Size":     size,
+			"Start":    start,
+			"End":      end,
+		},
+	})
+	go e.stream(c.Session, c.ID, d, r, start, end)
+	return nil
+}
+
+// stream 會將檔案的指定範圍逐一切成區塊傳送給客戶端，並在尚未被確認的位元組超過視窗大小時等待客戶端確認，
+// 傳送完畢後會附上這段內容的 SHA-256 摘要。客戶端逾時沒有確認時就會停止傳送。
+func (e *Engine) stream(sess *Session, id int, d *download, r io.ReadSeeker, start int64, end int64) {
+	defer e.endDownload(d, r)
+	h := newHash(HashSHA256)
+	buf := make([]byte, e.downloadChunkSize())
+	window := int64(e.downloadWindow() * len(buf))
+
+	for offset := start; offset < end; {
+		for offset-d.position() >= window {
+			if !e.waitDownload(d) {
+				sess.write(Response{
+					ID: id,
+					Result: H{
+						"Download": d.id,
+					},
+					Error: ResponseError{
+						Code:    StatusTimeout,
+						Message: ErrDownloadTimeout.Error(),
+					},
+				})
+				return
+			}
+		}
+		size := int64(len(buf))
+		if end-offset < size {
+			size = end - offset
+		}
+		n, err := io.ReadFull(r, buf[:size])
+		if err != nil {
+			sess.write(Response{
+				ID: id,
+				Result: H{
+					"Download": d.id,
+				},
+				Error: ResponseError{
+					Code:    StatusError,
+					Message: err.Error(),
+				},
+			})
+			return
+		}
+		h.Write(buf[:n])
+		sess.write(Response{
+			Event: "MegoDownloadChunk",
+			ID:    id,
+			Result: H{
+				"Download": d.id,
+				"Offset":   offset,
+				"Binary":   buf[:n],
+			},
+		})
+		offset += int64(n)
+	}
+	sess.write(Response{
+		Event: "MegoDownloadDone",
+		ID:    id,
+		Result: H{
+			"Download": d.id,
+			"Digest":   sumHash(HashSHA256, h),
+		},
+	})
+}
+
+// waitDownload 會等待客戶端確認指定下載的區塊，逾時沒有確認則回傳 `false`。
+func (e *Engine) waitDownload(d *download) bool {
+	expired := make(chan struct{})
+	timer := e.clock().AfterFunc(e.downloadTimeout(), func() {
+		close(expired)
+	})
+	defer timer.Stop()
+	select {
+	case <-d.signal:
+		return true
+	case <-expired:
+		return false
+	}
+}
+
+// acknowledgeDownload 會記錄客戶端回報已經接收的位元組位置，並通知傳送中的下載繼續傳送。
+func (e *Engine) acknowledgeDownload(sess *Session, id string, received int64) {
+	e.lock.RLock()
+	d, ok := e.downloads[id]
+	e.lock.RUnlock()
+	if !ok || d.session != sess.ID {
+		return
+	}
+	d.lock.Lock()
+	if received > d.received {
+		d.received = received
+	}
+	d.lock.Unlock()
+	select {
+	case d.signal <- struct{}{}:
+	default:
+	}
+}
+
+// endDownload 會停止追蹤已經結束的下載並關閉其檔案。
+func (e *Engine) endDownload(d *download, r io.ReadSeeker) {
+	e.lock.Lock()
+	delete(e.downloads, d.id)
+	e.lock.Unlock()
+	closeReader(r)
+}
+
+// closeReader 會在傳入的檔案實作了 `io.Closer` 時關閉它。
+func closeReader(r io.ReadSeeker) {
+	if v, ok := r.(io.Closer); ok {
+		v.Close()
+	}
+}
@@ -0,0 +1,73 @@
+package mego
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmihailenco/msgpack"
+)
+
+// closeRecorder 是會記錄自己是否已經被關閉的檔案。
+type closeRecorder struct {
+	*bytes.Reader
+	closed bool
+}
+
+func (r *closeRecorder) Close() error {
+	r.closed = true
+	return nil
+}
+
+func TestRespondFileRange(t *testing.T) {
+	tests := []struct {
+		name  string
+		rng   []int64
+		err   error
+		start int64
+		end   int64
+	}{
+		{"whole file", nil, nil, 0, 10},
+		{"from offset", []int64{4}, nil, 4, 10},
+		{"zero end means size", []int64{2, 0}, nil, 2, 10},
+		{"bounded", []int64{2, 6}, nil, 2, 6},
+		{"empty at end", []int64{10}, nil, 10, 10},
+		{"negative start", []int64{-1}, ErrInvalidRange, 0, 0},
+		{"start past size", []int64{11}, ErrInvalidRange, 0, 0},
+		{"start after end", []int64{6, 4}, ErrInvalidRange, 0, 0},
+		{"end past size", []int64{0, 11}, ErrInvalidRange, 0, 0},
+	}
+	for _, v := range tests {
+		e, sess, _ := newTestEngine()
+		c := newTestContext(e, sess, nil)
+		c.rng = v.rng
+		f := &closeRecorder{Reader: bytes.NewReader([]byte("0123456789"))}
+		err := c.RespondFile("file.txt", f)
+		assert.Equal(t, v.err, err, v.name)
+
+		// 第一則回應必定是下載資訊或錯誤，之後才是串流的區塊。
+		sess.lock.Lock()
+		msg := sess.queue[0]
+		sess.lock.Unlock()
+		var resp struct {
+			Event  string `msgpack:"v"`
+			Result struct {
+				Size  int64
+				Start int64
+				End   int64
+			} `msgpack:"r"`
+			Error ResponseError `msgpack:"e"`
+		}
+		assert.NoError(t, msgpack.Unmarshal(msg, &resp), v.name)
+		if v.err != nil {
+			assert.Equal(t, StatusInvalid, resp.Error.Code, v.name)
+			assert.True(t, f.closed, v.name)
+			assert.Len(t, e.downloads, 0, v.name)
+			continue
+		}
+		assert.Equal(t, "MegoDownload", resp.Event, v.name)
+		assert.Equal(t, int64(10), resp.Result.Size, v.name)
+		assert.Equal(t, v.start, resp.Result.Start, v.name)
+		assert.Equal(t, v.end, resp.Result.End, v.name)
+	}
+}
@@ -0,0 +1,72 @@
+package mego
+
+import "reflect"
+
+// encodeCacheSize 是編碼快取最多保留的資料數量。
+const encodeCacheSize = 8
+
+// encodeCache 會保留最近編碼過的資料，讓相同的資料不需要重複編碼。
+type encodeCache struct {
+	// entries 是最近編碼過的資料與其編碼結果。
+	entries []encodeEntry
+}
+
+// encodeEntry 是一筆已編碼的資料。
+type encodeEntry struct {
+	// value 是編碼前的資料。
+	value interface{}
+	// msg 是編碼後的內容。
+	msg []byte
+}
+
+// encode 會回傳與傳入資料相同的已編碼內容，沒有的話則呼叫 `fn` 編碼並保存結果。
+func (c *encodeCache) encode(value interface{}, fn func() ([]byte, error)) ([]byte, error) {
+	for _, v := range c.entries {
+		if identical(v.value, value) {
+			return v.msg, nil
+		}
+	}
+	msg, err := fn()
+	if err != nil {
+		return nil, err
+	}
+	if len(c.entries) >= encodeCacheSize {
+		c.entries = c.entries[1:]
+	}
+	c.entries = append(c.entries, encodeEntry{
+		value: value,
+		msg:   msg,
+	})
+	return msg, nil
+}
+
+// identical 會回傳兩個資料是否為同一個值。映射、切片等參考型態僅會比對是否指向相同的內容，而不會深入比對。
+func identical(a interface{}, b interface{}) (same bool) {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	ta := reflect.TypeOf(a)
+	if ta != reflect.TypeOf(b) {
+		return false
+	}
+	va := reflect.ValueOf(a)
+	vb := reflect.ValueOf(b)
+	switch ta.Kind() {
+	case reflect.Map, reflect.Ptr, reflect.Chan, reflect.UnsafePointer:
+		return va.Pointer() == vb.Pointer()
+	case reflect.Slice:
+		return va.Pointer() == vb.Pointer() && va.Len() == vb.Len()
+	case reflect.Func:
+		return false
+	}
+	if !ta.Comparable() {
+		return false
+	}
+	// 建構體中的介面欄位可能帶有無法比較的值，這種情況就當作不同的資料。
+	defer func() {
+		if recover() != nil {
+			same = false
+		}
+	}()
+	return a == b
+}
@@ -0,0 +1,74 @@
+package mego
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdentical(t *testing.T) {
+	m := map[string]int{"a": 1}
+	s := []int{1, 2, 3}
+	p := &struct{}{}
+	f := func() {}
+	tests := []struct {
+		name string
+		a    interface{}
+		b    interface{}
+		same bool
+	}{
+		{"both nil", nil, nil, true},
+		{"one nil", nil, 1, false},
+		{"equal ints", 1, 1, true},
+		{"different types", 1, int64(1), false},
+		{"equal strings", "a", "a", true},
+		{"same map", m, m, true},
+		{"equal maps", map[string]int{"a": 1}, map[string]int{"a": 1}, false},
+		{"same slice", s, s, true},
+		{"shorter slice", s, s[:2], false},
+		{"same pointer", p, p, true},
+		{"funcs", f, f, false},
+		{"equal structs", struct{ A int }{1}, struct{ A int }{1}, true},
+		{"uncomparable field", struct{ A interface{} }{s}, struct{ A interface{} }{s}, false},
+	}
+	for _, v := range tests {
+		assert.Equal(t, v.same, identical(v.a, v.b), v.name)
+	}
+}
+
+func TestEncodeCache(t *testing.T) {
+	var c encodeCache
+	calls := 0
+	encode := func(value interface{}) ([]byte, error) {
+		return c.encode(value, func() ([]byte, error) {
+			calls++
+			return []byte{byte(calls)}, nil
+		})
+	}
+
+	// 相同的資料只會被編碼一次。
+	a, _ := encode(1)
+	b, _ := encode(1)
+	assert.Equal(t, a, b)
+	assert.Equal(t, 1, calls)
+
+	// 超過快取大小時會捨棄最舊的資料。
+	for i := 2; i <= encodeCacheSize+1; i++ {
+		encode(i)
+	}
+	assert.Len(t, c.entries, encodeCacheSize)
+	calls = 0
+	encode(encodeCacheSize + 1)
+	assert.Equal(t, 0, calls)
+	encode(1)
+	assert.Equal(t, 1, calls)
+
+	// 編碼失敗的結果不會被保存。
+	c = encodeCache{}
+	_, err := c.encode("a", func() ([]byte, error) {
+		return nil, errors.New("failed")
+	})
+	assert.Error(t, err)
+	assert.Len(t, c.entries, 0)
+}
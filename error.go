@@ -18,10 +18,48 @@ var (
 	ErrEventNotFound = errors.New("mego: the event doesn't exist")
 	// ErrChannelNotFound 表示欲發送的事件存在，但目標頻道沒有被初始化或任何客戶端監聽而無法找到因此發送失敗。
 	ErrChannelNotFound = errors.New("mego: the channel doesn't exist")
+	// ErrChannelFull 表示欲訂閱的頻道已經達到事件所設置的訂閱者上限。
+	ErrChannelFull = errors.New("mego: the channel is full")
+	// ErrTooManyChannels 表示事件的頻道數量已經達到上限，無法再建立新的頻道。
+	ErrTooManyChannels = errors.New("mego: the event has too many channels")
+	// ErrNotAuthorized 表示階段缺少事件所要求的認證資料。
+	ErrNotAuthorized = errors.New("mego: the session is not authorized")
+	// ErrUserNotFound 表示指定的使用者目前沒有任何連線中的階段。
+	ErrUserNotFound = errors.New("mego: the user has no sessions")
+	// ErrSessionNotFound 表示指定的階段不存在或已經離線。
+	ErrSessionNotFound = errors.New("mego: the session was not found")
 	// ErrFileNotFound 表示欲取得的檔案並不存在，可能是客戶端上傳不完整。
 	ErrFileNotFound = errors.New("mego: the file was not found")
+	// ErrUploadNotFound 表示欲接續的區塊上傳不存在，或是屬於其他階段。
+	ErrUploadNotFound = errors.New("mego: the upload was not found")
+	// ErrFileFinalized 表示欲寫入的檔案已經完成寫入，不能再附加內容。
+	ErrFileFinalized = errors.New("mego: the file was finalized")
+	// ErrChecksumMismatch 表示接收到的區塊或檔案與客戶端所傳來的雜湊不符，內容可能已經損毀。
+	ErrChecksumMismatch = errors.New("mego: the checksum does not match")
+	// ErrFileNotAllowed 表示上傳的檔案種類或副檔名不被此方法所允許。
+	ErrFileNotAllowed = errors.New("mego: the file is not allowed")
+	// ErrTooManyFiles 表示同個檔案欄位中上傳了超過方法所允許數量的檔案。
+	ErrTooManyFiles = errors.New("mego: too many files")
+	// ErrInvalidChunk 表示接收到的區塊格式不正確，例如區塊編號超出範圍。
+	ErrInvalidChunk = errors.New("mego: the chunk is invalid")
+	// ErrFileTooLarge 表示上傳的檔案或區塊超過了方法所允許的大小。
+	ErrFileTooLarge = errors.New("mego: the file is too large")
+	// ErrRequestTooLarge 表示請求的訊息超過了方法所允許的大小。
+	ErrRequestTooLarge = errors.New("mego: the request is too large")
+	// ErrProcessTimeout 表示檔案處理函式沒有在時限內處理完畢。
+	ErrProcessTimeout = errors.New("mego: the file processing timed out")
+	// ErrInvalidRange 表示客戶端所要求的下載範圍超出了檔案大小。
+	ErrInvalidRange = errors.New("mego: the requested range is invalid")
+	// ErrDownloadTimeout 表示客戶端過久沒有確認已經接收的下載區塊。
+	ErrDownloadTimeout = errors.New("mego: the download timed out")
 	// ErrKeyNotFound 表示欲從鍵值組中取得的鍵名並不存在。
 	ErrKeyNotFound = errors.New("mego: the key was not found")
+	// ErrSubscriptionRefused 表示客戶端欲訂閱的事件請求被拒。
+	ErrSubscriptionRefused = errors.New("mego: the event subscription was refused")
+	// ErrPublishRefused 表示客戶端欲發布的事件被拒。
+	ErrPublishRefused = errors.New("mego: the event publishing was refused")
+	// ErrInvalidSchedule 表示排程規則的格式不正確，或是永遠不會觸發。
+	ErrInvalidSchedule = errors.New("mego: the schedule spec is invalid")
 	// ErrPanicRecovered 表示 Panic 發生了但已回復正常。
 	ErrPanicRecovered = errors.New("mego: panic recovered")
 )
@@ -1,18 +1,65 @@
 package mego
 
+import "sync"
+
 // Event 呈現了單一個事件。
 type Event struct {
 	// Name 是這個事件的名稱。
 	Name string
 	// Channels 是這個事件的所有頻道。
 	Channels map[string]*Channel
+	// Option 是這個事件的選項。
+	Option *EventOption
 
 	// engine 是這個事件所依存的主要引擎。
 	engine *Engine
 }
 
+// EventOption 是一個事件的選項。
+type EventOption struct {
+	// SubscribeHandler 是此事件獨有的訂閱處理函式，會在引擎的訂閱處理函式之後呼叫，兩者都允許時客戶端才能訂閱。
+	SubscribeHandler SubscribeHandler
+	// RequireKeys 是客戶端階段必須帶有的鍵值組名稱，例如：`UserID`。缺少任一鍵值組的客戶端會以 `StatusNotAuthorized` 被拒。
+	RequireKeys []string
+	// MaxSubscribers 是每個頻道最多能有幾個訂閱者，已滿的頻道會以 `StatusFull` 拒絕客戶端。`0` 表示無上限。
+	MaxSubscribers int
+	// MaxChannels 是此事件最多能有幾個頻道。`0` 表示無上限。
+	MaxChannels int
+	// StrictChannels 表示客戶端不能藉由訂閱來建立新的頻道，僅能訂閱已經透過 `Channel` 建立的頻道。
+	StrictChannels bool
+	// Mailbox 表示透過 `EmitToUser` 或 `EmitToSession` 廣播此事件時，會先將事件放入離線信箱，
+	// 直到客戶端確認收到為止，讓離線的使用者能在重新連線時收到。透過 `Emit` 廣播至頻道時，
+	// 曾經訂閱此頻道但目前不在線上的使用者也會在其使用者信箱中收到。
+	Mailbox bool
+	// Acknowledge 表示此事件需要客戶端確認收到，沒有在期限內確認的客戶端會被重新傳送，
+	// 直到超過引擎選項的 `AckRetries` 後呼叫 `HandleAckFailure` 所設置的處理函式。
+	Acknowledge bool
+}
+
+// Channel 會取得此事件中的指定頻道，頻道不存在時會建立一個。
+func (e *Event) Channel(name string) *Channel {
+	e.engine.lock.Lock()
+	defer e.engine.lock.Unlock()
+	return e.channel(name)
+}
+
+// channel 會取得或建立指定的頻道，呼叫前必須先取得引擎的寫入鎖。
+func (e *Event) channel(name string) *Channel {
+	ch, ok := e.Channels[name]
+	if !ok {
+		ch = &Channel{
+			Name:  name,
+			Event: e,
+		}
+		e.Channels[name] = ch
+	}
+	return ch
+}
+
 // Destroy 會摧毀一個事件和所有頻道避免其階段接收到相關事件。
 func (e *Event) Destroy() {
+	e.engine.lock.Lock()
+	defer e.engine.lock.Unlock()
 	delete(e.engine.Events, e.Name)
 }
 
@@ -24,18 +71,110 @@ type Channel struct {
 	Sessions []*Session
 	// Event 是這個頻道的父事件。
 	Event *Event
+
+	// presences 存放每個訂閱者在此頻道中的自訂在線資料。
+	presences map[*Session]interface{}
+	// sequence 是此頻道最後一則事件的序號。
+	sequence int
+	// state 是此頻道的共享狀態文件。
+	state H
+	// version 是共享狀態的版本，每次更新都會遞增。
+	version int
+	// lock 是避免訂閱者清單同時被新增、移除而發生資料競爭的讀寫鎖。
+	lock sync.RWMutex
+	// sendLock 會在配發序號到寫入給訂閱者之間持有，確保訂閱者依照序號的順序收到事件。
+	sendLock sync.Mutex
+	// members 是訂閱過此頻道的使用者編號，僅用於開啟離線信箱的事件。使用者離線後仍會保留，直到明確取消訂閱為止。
+	members map[string]bool
 }
 
 // Destroy 會摧毀一個頻道避免其階段接收到相關事件。
 func (c *Channel) Destroy() {
+	c.Event.engine.lock.Lock()
+	defer c.Event.engine.lock.Unlock()
 	delete(c.Event.Channels, c.Name)
 }
 
 // Kick 會移除有註冊此頻道指定階段，避免繼續接收到相關事件。
 func (c *Channel) Kick(id string) {
-	for i, v := range c.Sessions {
+	c.lock.RLock()
+	var sess *Session
+	for _, v := range c.Sessions {
 		if v.ID == id {
+			sess = v
+			break
+		}
+	}
+	c.lock.RUnlock()
+	if sess == nil {
+		return
+	}
+	if presence, ok := c.remove(sess); ok {
+		c.announce("MegoLeave", sess, presence)
+	}
+}
+
+// add 會將指定階段與其在線資料加入此頻道的訂閱者清單，並回傳是否為新加入的訂閱者。
+// 已經存在的階段則不會重複加入，訂閱者數量已經達到 `max` 時則會回傳 `ErrChannelFull`。
+func (c *Channel) add(sess *Session, presence interface{}, max int) (bool, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, v := range c.Sessions {
+		if v == sess {
+			return false, nil
+		}
+	}
+	if max > 0 && len(c.Sessions) >= max {
+		return false, ErrChannelFull
+	}
+	c.Sessions = append(c.Sessions, sess)
+	if c.presences == nil {
+		c.presences = make(map[*Session]interface{})
+	}
+	c.presences[sess] = presence
+	return true, nil
+}
+
+// remove 會將指定階段從此頻道的訂閱者清單中移除，並回傳其在線資料與是否確實有移除。
+func (c *Channel) remove(sess *Session) (interface{}, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for i, v := range c.Sessions {
+		if v == sess {
 			c.Sessions = append(c.Sessions[:i], c.Sessions[i+1:]...)
+			presence := c.presences[sess]
+			delete(c.presences, sess)
+			return presence, true
 		}
 	}
+	return nil, false
+}
+
+// has 會回傳指定階段是否有訂閱此頻道。
+func (c *Channel) has(sess *Session) bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	for _, v := range c.Sessions {
+		if v == sess {
+			return true
+		}
+	}
+	return false
+}
+
+// next 會遞增並回傳此頻道的下一個事件序號。
+func (c *Channel) next() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.sequence++
+	return c.sequence
+}
+
+// sessions 會回傳一份訂閱者清單的複本，供廣播時遍歷而不必持有鎖。
+func (c *Channel) sessions() []*Session {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	list := make([]*Session, len(c.Sessions))
+	copy(list, c.Sessions)
+	return list
 }
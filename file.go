@@ -1,6 +1,11 @@
 package mego
 
-import "os"
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
 
 const (
 	// B 為 Byte。
@@ -23,18 +28,84 @@ type File struct {
 	Size int
 	// Extension 是這個檔案的副檔名。
 	Extension string
-	// Path 為此檔案上傳後的本地路徑。
+	// Path 為此檔案上傳後的本地路徑，僅有存放在 `LocalFileStore` 的檔案才有此路徑。
 	Path string
+	// Key 是此檔案在檔案儲存裝置中的鍵名。
+	Key string
+	// ContentType 是依照檔案內容的前幾個位元組所判斷的 MIME 種類，例如：`image/png`。
+	ContentType string
+	// Hash 是此檔案內容以 `sha256:十六進位雜湊` 表示的摘要，若客戶端有傳來摘要則已經過檢查。
+	Hash string
 	// Keys 為此檔案的鍵值組，可供開發者存放自訂資料。
 	Keys map[string]interface{}
+
+	// store 是存放此檔案的儲存裝置。
+	store FileStore
+	// claimed 表示此檔案已經被處理函式保留、移動或移除，請求結束後不會被自動移除。
+	claimed bool
+}
+
+// newFile 會以存放在儲存裝置中的檔案建立一個檔案建構體，並從原始名稱中取得名稱與副檔名。
+func newFile(store FileStore, key string, name string, size int64) *File {
+	ext := filepath.Ext(name)
+	f := &File{
+		Name:  strings.TrimSuffix(name, ext),
+		Size:  int(size),
+		Key:   key,
+		Keys:  make(map[string]interface{}),
+		store: store,
+	}
+	if ext != "" {
+		f.Extension = ext[1:]
+	}
+	if v, ok := store.(*LocalFileStore); ok {
+		f.Path = v.Path(key)
+	}
+	return f
+}
+
+// Open 會開啟這個檔案供讀取，不論檔案存放在哪種儲存裝置中。
+func (f *File) Open() (io.ReadCloser, error) {
+	if f.store == nil {
+		return os.Open(f.Path)
+	}
+	return f.store.Open(f.Key)
+}
+
+// Keep 會保留這個檔案，上傳的檔案預設會在請求結束後被移除，除非有被移動或是保留。
+// 如果處理函式需要在請求結束後才處理檔案（例如交給其他 Goroutine），請在處理函式返回前呼叫此函式。
+func (f *File) Keep() *File {
+	f.claimed = true
+	return f
 }
 
 // Remove 會移除這個檔案。
 func (f *File) Remove() error {
-	return os.Remove(f.Path)
+	f.claimed = true
+	if f.store == nil {
+		return os.Remove(f.Path)
+	}
+	return f.store.Remove(f.Key)
 }
 
-// Move 會移動接收到的檔案到指定路徑。
+// Move 會移動接收到的檔案到指定鍵名。存放在 `LocalFileStore` 的檔案能以絕對路徑移出儲存裝置的資料夾，
+// 相對路徑則是位於儲存裝置的資料夾中。
 func (f *File) Move(dest string) error {
-	return os.Rename(f.Path, dest)
+	if f.store == nil {
+		if err := os.Rename(f.Path, dest); err != nil {
+			return err
+		}
+		f.Path = dest
+		f.claimed = true
+		return nil
+	}
+	if err := f.store.Move(f.Key, dest); err != nil {
+		return err
+	}
+	f.Key = dest
+	f.claimed = true
+	if v, ok := f.store.(*LocalFileStore); ok {
+		f.Path = v.Path(dest)
+	}
+	return nil
 }
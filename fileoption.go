@@ -0,0 +1,135 @@
+package mego
+
+import (
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// sniffLength 是判斷檔案 MIME 種類時最多讀取的位元組數。
+const sniffLength = 512
+
+var (
+	// DefaultMaxFileSize 是沒有設置 `MaxFileSize` 時預設允許的檔案最大位元組。
+	DefaultMaxFileSize = 1 * GB
+)
+
+// FileOption 是一個檔案欄位的上傳限制，不符合限制的檔案會在呼叫方法處理函式前以 `StatusInvalid` 拒絕。
+type FileOption struct {
+	// Types 是允許的 MIME 種類，例如：`image/png`，也能以 `image/*` 允許同類的所有種類。空白表示不限制。
+	// 檔案的種類是依照內容的前幾個位元組判斷，而不是客戶端所提供的檔案名稱。
+	Types []string
+	// Extensions 是允許的副檔名，例如：`jpg`，不分大小寫。空白表示不限制。
+	Extensions []string
+	// AllowNoExtension 表示在有限制副檔名時，是否仍接受沒有副檔名的檔案。
+	AllowNoExtension bool
+	// MaxFiles 是此欄位最多能上傳幾個檔案，`0` 表示不限制。
+	MaxFiles int
+}
+
+// allowExtension 會回傳指定檔案名稱的副檔名是否被允許。
+func (o *FileOption) allowExtension(name string) bool {
+	if len(o.Extensions) == 0 {
+		return true
+	}
+	ext := strings.TrimPrefix(filepath.Ext(name), ".")
+	if ext == "" {
+		return o.AllowNoExtension
+	}
+	for _, v := range o.Extensions {
+		if strings.EqualFold(strings.TrimPrefix(v, "."), ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowType 會回傳指定的 MIME 種類是否被允許。
+func (o *FileOption) allowType(contentType string) bool {
+	if len(o.Types) == 0 {
+		return true
+	}
+	media := contentType
+	if i := strings.Index(media, ";"); i != -1 {
+		media = media[:i]
+	}
+	media = strings.ToLower(strings.TrimSpace(media))
+	for _, v := range o.Types {
+		v = strings.ToLower(v)
+		if v == media || (strings.HasSuffix(v, "/*") && strings.HasPrefix(media, v[:len(v)-1])) {
+			return true
+		}
+	}
+	return false
+}
+
+// maxSize 會回傳指定方法允許接收的最大位元組，方法的選項會覆蓋引擎設定，`0` 表示無上限。
+func (e *Engine) maxSize(m *Method) int {
+	if m != nil && m.Option != nil && m.Option.MaxSize != 0 {
+		return m.Option.MaxSize
+	}
+	return e.Option.MaxSize
+}
+
+// maxChunkSize 會回傳指定方法允許的區塊最大位元組，方法的選項會覆蓋引擎設定，`0` 表示無上限。
+func (e *Engine) maxChunkSize(m *Method) int {
+	if m != nil && m.Option != nil && m.Option.MaxChunkSize != 0 {
+		return m.Option.MaxChunkSize
+	}
+	return e.Option.MaxChunkSize
+}
+
+// maxFileSize 會回傳指定方法允許的檔案最大位元組，方法的選項會覆蓋引擎設定。
+func (e *Engine) maxFileSize(m *Method) int64 {
+	if m != nil && m.Option != nil && m.Option.MaxFileSize != 0 {
+		return int64(m.Option.MaxFileSize)
+	}
+	if e.Option.MaxFileSize == 0 {
+		return int64(DefaultMaxFileSize)
+	}
+	return int64(e.Option.MaxFileSize)
+}
+
+// fileStatus 會回傳檔案被拒絕時應該回應給客戶端的狀態碼。
+func fileStatus(err error) int {
+	if err == ErrFileTooLarge {
+		return StatusFileTooLarge
+	}
+	return StatusInvalid
+}
+
+// fileOption 會回傳此方法中指定檔案欄位的上傳限制，沒有設置時回傳 `nil`。
+func (m *Method) fileOption(field string) *FileOption {
+	if m == nil || m.Option == nil {
+		return nil
+	}
+	o, ok := m.Option.Files[field]
+	if !ok {
+		return nil
+	}
+	return &o
+}
+
+// sniff 會依照檔案內容的前幾個位元組判斷其 MIME 種類。
+func sniff(data []byte) string {
+	if len(data) > sniffLength {
+		data = data[:sniffLength]
+	}
+	return http.DetectContentType(data)
+}
+
+// sniffFile 會讀取檔案的前幾個位元組並判斷其 MIME 種類，無法讀取時回傳空字串。
+func sniffFile(f *File) string {
+	r, err := f.Open()
+	if err != nil {
+		return ""
+	}
+	defer r.Close()
+	buf := make([]byte, sniffLength)
+	n, err := io.ReadFull(r, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return ""
+	}
+	return sniff(buf[:n])
+}
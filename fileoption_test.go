@@ -0,0 +1,68 @@
+package mego
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSniff(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		typ  string
+	}{
+		{"png", []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), "image/png"},
+		{"gif", []byte("GIF89a\x01\x00\x01\x00"), "image/gif"},
+		{"pdf", []byte("%PDF-1.4\n"), "application/pdf"},
+		{"text", []byte("hello, world"), "text/plain; charset=utf-8"},
+		{"binary", []byte{0x00, 0x01, 0x02, 0x03}, "application/octet-stream"},
+		{"only leading bytes", append(bytes.Repeat([]byte("a"), sniffLength), 0x00, 0x01), "text/plain; charset=utf-8"},
+	}
+	for _, v := range tests {
+		assert.Equal(t, v.typ, sniff(v.data), v.name)
+	}
+}
+
+func TestFileOptionAllowType(t *testing.T) {
+	tests := []struct {
+		name  string
+		types []string
+		typ   string
+		allow bool
+	}{
+		{"unrestricted", nil, "application/octet-stream", true},
+		{"exact", []string{"image/png"}, "image/png", true},
+		{"parameters", []string{"text/plain"}, "text/plain; charset=utf-8", true},
+		{"case insensitive", []string{"Image/PNG"}, "image/png", true},
+		{"wildcard", []string{"image/*"}, "image/gif", true},
+		{"wildcard other type", []string{"image/*"}, "application/pdf", false},
+		{"wildcard prefix only", []string{"image/*"}, "imagefoo/png", false},
+		{"mismatch", []string{"image/png"}, "image/gif", false},
+	}
+	for _, v := range tests {
+		o := &FileOption{Types: v.types}
+		assert.Equal(t, v.allow, o.allowType(v.typ), v.name)
+	}
+}
+
+func TestFileOptionAllowExtension(t *testing.T) {
+	tests := []struct {
+		name   string
+		option FileOption
+		file   string
+		allow  bool
+	}{
+		{"unrestricted", FileOption{}, "a.exe", true},
+		{"allowed", FileOption{Extensions: []string{"jpg"}}, "a.jpg", true},
+		{"case insensitive", FileOption{Extensions: []string{"jpg"}}, "a.JPG", true},
+		{"leading dot", FileOption{Extensions: []string{".png"}}, "a.png", true},
+		{"last extension", FileOption{Extensions: []string{"jpg"}}, "a.jpg.exe", false},
+		{"no extension", FileOption{Extensions: []string{"jpg"}}, "a", false},
+		{"allow no extension", FileOption{Extensions: []string{"jpg"}, AllowNoExtension: true}, "a", true},
+	}
+	for _, v := range tests {
+		assert.Equal(t, v.allow, v.option.allowExtension(v.file), v.name)
+	}
+}
@@ -0,0 +1,323 @@
+package mego
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// FileStore 是上傳檔案的儲存介面，開發者能以此將上傳的檔案直接存放至物件儲存等外部裝置。
+// 檔案會先透過 `Create` 建立，接著以 `Append` 逐一附加區塊內容（或以 `WriteAt` 寫入至指定位置），最後以 `Finalize` 完成寫入。
+type FileStore interface {
+	// Create 會依照檔案的原始名稱建立一個空白檔案，並回傳其在儲存裝置中的鍵名。
+	Create(name string) (key string, err error)
+	// Append 會將區塊內容附加至指定檔案的尾端。
+	Append(key string, chunk []byte) error
+	// WriteAt 會將區塊內容寫入至指定檔案的指定位元組位置，用於以任意順序抵達的區塊。
+	WriteAt(key string, chunk []byte, offset int64) error
+	// Finalize 會完成指定檔案的寫入並回傳其大小，完成後的檔案便不能再附加內容。
+	Finalize(key string) (size int64, err error)
+	// Open 會開啟指定檔案供讀取。
+	Open(key string) (io.ReadCloser, error)
+	// Remove 會移除指定檔案。
+	Remove(key string) error
+	// Move 會將指定檔案移動至新的鍵名。
+	Move(key string, dest string) error
+}
+
+// FileSweeper 是能夠清除中斷而遺留之檔案的檔案儲存裝置，引擎的清潔工會定期呼叫，
+// 用以移除因為伺服器重啟等原因而沒有被追蹤到的上傳中檔案。
+type FileSweeper interface {
+	// Sweep 會移除在指定時間之後就沒有再被寫入、且尚未完成寫入的檔案。
+	Sweep(before time.Time) error
+}
+
+// chunkPrefix 是本地檔案尚未完成寫入時的檔名前綴，清潔工能以此找出中斷而遺留的檔案。
+const chunkPrefix = "MEGO_CHUNK_"
+
+// newFileKey 會依照檔案的原始名稱產生一個不重複的鍵名，並保留其副檔名。
+func newFileKey(name string) string {
+	return uuid.NewV4().String() + filepath.Ext(name)
+}
+
+// NewLocalFileStore 會建立一個將檔案存放在指定資料夾中的儲存裝置，資料夾不存在時會自動建立。
+func NewLocalFileStore(dir string) (*LocalFileStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &LocalFileStore{
+		Dir: dir,
+	}, nil
+}
+
+// LocalFileStore 是將檔案存放在本地資料夾中的儲存裝置，也是引擎預設所使用的儲存裝置。
+// 尚未完成寫入的檔案會以 `MEGO_CHUNK_` 作為檔名前綴，並在完成寫入時重新命名。
+type LocalFileStore struct {
+	// Dir 是存放檔案的資料夾路徑。
+	Dir string
+}
+
+// Path 會回傳指定鍵名的本地路徑。絕對路徑的鍵名會被直接使用，其餘則位於儲存裝置的資料夾中。
+func (l *LocalFileStore) Path(key string) string {
+	if filepath.IsAbs(key) {
+		return key
+	}
+	return filepath.Join(l.Dir, key)
+}
+
+// partial 會回傳指定鍵名尚未完成寫入時的本地路徑。
+func (l *LocalFileStore) partial(key string) string {
+	p := l.Path(key)
+	return filepath.Join(filepath.Dir(p), chunkPrefix+filepath.Base(p))
+}
+
+// Create 會在資料夾中建立一個尚未完成寫入的空白檔案。
+func (l *LocalFileStore) Create(name string) (string, error) {
+	key := newFileKey(name)
+	f, err := os.OpenFile(l.partial(key), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return "", err
+	}
+	return key, f.Close()
+}
+
+// Append 會將區塊內容附加至指定檔案的尾端。
+func (l *LocalFileStore) Append(key string, chunk []byte) error {
+	f, err := os.OpenFile(l.partial(key), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(chunk); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// WriteAt 會將區塊內容寫入至指定檔案的指定位元組位置。
+func (l *LocalFileStore) WriteAt(key string, chunk []byte, offset int64) error {
+	f, err := os.OpenFile(l.partial(key), os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteAt(chunk, offset); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Finalize 會將尚未完成寫入的檔案重新命名為正式的鍵名，並回傳其大小。
+func (l *LocalFileStore) Finalize(key string) (int64, error) {
+	if err := os.Rename(l.partial(key), l.Path(key)); err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+	s, err := os.Stat(l.Path(key))
+	if err != nil {
+		return 0, err
+	}
+	return s.Size(), nil
+}
+
+// Open 會開啟指定檔案供讀取，尚未完成寫入的檔案也能被讀取。
+func (l *LocalFileStore) Open(key string) (io.ReadCloser, error) {
+	f, err := os.Open(l.Path(key))
+	if os.IsNotExist(err) {
+		return os.Open(l.partial(key))
+	}
+	return f, err
+}
+
+// Remove 會移除指定檔案，不論其是否已經完成寫入。
+func (l *LocalFileStore) Remove(key string) error {
+	err := os.Remove(l.Path(key))
+	if os.IsNotExist(err) {
+		return os.Remove(l.partial(key))
+	}
+	return err
+}
+
+// Move 會將指定檔案移動至新的鍵名，絕對路徑的鍵名能將檔案移出儲存裝置的資料夾。
+func (l *LocalFileStore) Move(key string, dest string) error {
+	return os.Rename(l.Path(key), l.Path(dest))
+}
+
+// Sweep 會移除資料夾中在指定時間之後就沒有再被寫入、且尚未完成寫入的檔案。
+func (l *LocalFileStore) Sweep(before time.Time) error {
+	files, err := ioutil.ReadDir(l.Dir)
+	if err != nil {
+		return err
+	}
+	for _, v := range files {
+		if v.IsDir() || !strings.HasPrefix(v.Name(), chunkPrefix) || !v.ModTime().Before(before) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(l.Dir, v.Name())); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewMemoryFileStore 會建立一個將檔案存放在記憶體中的儲存裝置，適合用於測試。
+func NewMemoryFileStore() *MemoryFileStore {
+	return &MemoryFileStore{
+		files: make(map[string]*memoryFile),
+	}
+}
+
+// MemoryFileStore 是將檔案存放在記憶體中的儲存裝置，引擎重啟後檔案就會消失。
+type MemoryFileStore struct {
+	// files 以鍵名存放所有的檔案。
+	files map[string]*memoryFile
+	// lock 是避免檔案同時被讀寫的讀寫鎖。
+	lock sync.RWMutex
+}
+
+// memoryFile 是存放在記憶體中的一個檔案。
+type memoryFile struct {
+	// data 是檔案的內容。
+	data []byte
+	// finalized 表示此檔案已經完成寫入。
+	finalized bool
+}
+
+// Create 會建立一個空白檔案。
+func (m *MemoryFileStore) Create(name string) (string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	key := newFileKey(name)
+	m.files[key] = &memoryFile{}
+	return key, nil
+}
+
+// Append 會將區塊內容附加至指定檔案的尾端。
+func (m *MemoryFileStore) Append(key string, chunk []byte) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	f, ok := m.files[key]
+	if !ok {
+		return ErrFileNotFound
+	}
+	if f.finalized {
+		return ErrFileFinalized
+	}
+	f.data = append(f.data, chunk...)
+	return nil
+}
+
+// WriteAt 會將區塊內容寫入至指定檔案的指定位元組位置，超出檔案尾端時會以零補齊中間的空隙。
+func (m *MemoryFileStore) WriteAt(key string, chunk []byte, offset int64) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	f, ok := m.files[key]
+	if !ok {
+		return ErrFileNotFound
+	}
+	if f.finalized {
+		return ErrFileFinalized
+	}
+	if end := int(offset) + len(chunk); end > len(f.data) {
+		f.data = append(f.data, make([]byte, end-len(f.data))...)
+	}
+	copy(f.data[offset:], chunk)
+	return nil
+}
+
+// Finalize 會完成指定檔案的寫入並回傳其大小。
+func (m *MemoryFileStore) Finalize(key string) (int64, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	f, ok := m.files[key]
+	if !ok {
+		return 0, ErrFileNotFound
+	}
+	f.finalized = true
+	return int64(len(f.data)), nil
+}
+
+// Open 會開啟指定檔案供讀取。
+func (m *MemoryFileStore) Open(key string) (io.ReadCloser, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	f, ok := m.files[key]
+	if !ok {
+		return nil, ErrFileNotFound
+	}
+	return ioutil.NopCloser(bytes.NewReader(f.data)), nil
+}
+
+// Remove 會移除指定檔案。
+func (m *MemoryFileStore) Remove(key string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, ok := m.files[key]; !ok {
+		return ErrFileNotFound
+	}
+	delete(m.files, key)
+	return nil
+}
+
+// Move 會將指定檔案移動至新的鍵名，新鍵名已經存在時會被覆蓋。
+func (m *MemoryFileStore) Move(key string, dest string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	f, ok := m.files[key]
+	if !ok {
+		return ErrFileNotFound
+	}
+	delete(m.files, key)
+	m.files[dest] = f
+	return nil
+}
+
+// fileStore 會回傳引擎所使用的檔案儲存裝置，未指定時會使用系統暫存資料夾中專屬的 `mego` 資料夾，
+// 如此一來清潔工就只會清理 Mego 自己的檔案，而不會動到其他程式放在暫存資料夾中的檔案。
+func (e *Engine) fileStore() FileStore {
+	// 儲存裝置只會在第一次使用時建立，之後僅需要讀取鎖。
+	e.lock.RLock()
+	store := e.Option.FileStore
+	e.lock.RUnlock()
+	if store != nil {
+		return store
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.Option.FileStore == nil {
+		dir := filepath.Join(os.TempDir(), "mego")
+		os.MkdirAll(dir, 0700)
+		e.Option.FileStore = &LocalFileStore{
+			Dir: dir,
+		}
+	}
+	return e.Option.FileStore
+}
+
+// storeFile 會將完整上傳的檔案內容存放至儲存裝置並回傳檔案建構體。
+func (e *Engine) storeFile(name string, binary []byte) (*File, error) {
+	store := e.fileStore()
+	key, err := store.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := store.Append(key, binary); err != nil {
+		store.Remove(key)
+		return nil, err
+	}
+	size, err := store.Finalize(key)
+	if err != nil {
+		store.Remove(key)
+		return nil, err
+	}
+	f := newFile(store, key, name, size)
+	f.Hash = checksum(HashSHA256, binary)
+	f.ContentType = sniff(binary)
+	return f, nil
+}
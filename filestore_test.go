@@ -0,0 +1,112 @@
+package mego
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeAtTests 是以任意順序寫入區塊的測試案例。
+var writeAtTests = []struct {
+	name    string
+	chunks  []string
+	offsets []int64
+	expect  string
+}{
+	{"in order", []string{"abc", "def"}, []int64{0, 3}, "abcdef"},
+	{"reversed", []string{"def", "abc"}, []int64{3, 0}, "abcdef"},
+	{"gap", []string{"c"}, []int64{2}, "\x00\x00c"},
+	{"overwrite", []string{"abc", "xy"}, []int64{0, 1}, "axy"},
+	{"duplicate", []string{"ab", "cd", "cd"}, []int64{0, 2, 2}, "abcd"},
+}
+
+func TestMemoryFileStoreWriteAt(t *testing.T) {
+	for _, v := range writeAtTests {
+		m := NewMemoryFileStore()
+		key, err := m.Create("test.txt")
+		assert.NoError(t, err, v.name)
+		for i, c := range v.chunks {
+			assert.NoError(t, m.WriteAt(key, []byte(c), v.offsets[i]), v.name)
+		}
+		size, err := m.Finalize(key)
+		assert.NoError(t, err, v.name)
+		assert.Equal(t, int64(len(v.expect)), size, v.name)
+		assert.Equal(t, v.expect, string(m.files[key].data), v.name)
+
+		// 完成寫入的檔案就不能再寫入。
+		assert.Equal(t, ErrFileFinalized, m.WriteAt(key, []byte("a"), 0), v.name)
+	}
+	assert.Equal(t, ErrFileNotFound, NewMemoryFileStore().WriteAt("missing", []byte("a"), 0))
+}
+
+func TestLocalFileStoreWriteAt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mego")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	l, err := NewLocalFileStore(dir)
+	assert.NoError(t, err)
+
+	for _, v := range writeAtTests {
+		key, err := l.Create("test.txt")
+		assert.NoError(t, err, v.name)
+		assert.Equal(t, ".txt", filepath.Ext(key), v.name)
+		for i, c := range v.chunks {
+			assert.NoError(t, l.WriteAt(key, []byte(c), v.offsets[i]), v.name)
+		}
+		size, err := l.Finalize(key)
+		assert.NoError(t, err, v.name)
+		assert.Equal(t, int64(len(v.expect)), size, v.name)
+		b, err := ioutil.ReadFile(l.Path(key))
+		assert.NoError(t, err, v.name)
+		assert.Equal(t, v.expect, string(b), v.name)
+	}
+}
+
+func TestLocalFileStoreSweep(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mego")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	l, err := NewLocalFileStore(dir)
+	assert.NoError(t, err)
+
+	old, err := l.Create("old.txt")
+	assert.NoError(t, err)
+	recent, err := l.Create("recent.txt")
+	assert.NoError(t, err)
+	done, err := l.Create("done.txt")
+	assert.NoError(t, err)
+	_, err = l.Finalize(done)
+	assert.NoError(t, err)
+	other := filepath.Join(dir, "other.txt")
+	assert.NoError(t, ioutil.WriteFile(other, nil, 0644))
+
+	now := time.Now()
+	past := now.Add(-time.Hour)
+	for _, v := range []string{l.partial(old), l.Path(done), other} {
+		assert.NoError(t, os.Chtimes(v, past, past))
+	}
+
+	// 只有太久沒有被寫入、且尚未完成寫入的檔案會被移除。
+	assert.NoError(t, l.Sweep(now.Add(-time.Minute)))
+	_, err = os.Stat(l.partial(old))
+	assert.True(t, os.IsNotExist(err))
+	for _, v := range []string{l.partial(recent), l.Path(done), other} {
+		_, err = os.Stat(v)
+		assert.NoError(t, err, v)
+	}
+}
+
+func TestDefaultFileStore(t *testing.T) {
+	e := New()
+	l, ok := e.fileStore().(*LocalFileStore)
+	assert.True(t, ok)
+	// 預設的儲存裝置不會直接使用共用的系統暫存資料夾。
+	assert.Equal(t, filepath.Join(os.TempDir(), "mego"), l.Dir)
+	s, err := os.Stat(l.Dir)
+	assert.NoError(t, err)
+	assert.True(t, s.IsDir())
+}
@@ -0,0 +1,43 @@
+package mego
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChecksum(t *testing.T) {
+	tests := []struct {
+		algorithm string
+		data      string
+		sum       string
+	}{
+		{HashSHA256, "abc", "sha256:ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+		{HashCRC32C, "123456789", "crc32c:e3069283"},
+		{"md5", "abc", ""},
+	}
+	for _, v := range tests {
+		assert.Equal(t, v.sum, checksum(v.algorithm, []byte(v.data)), v.algorithm)
+	}
+}
+
+func TestVerifyChecksum(t *testing.T) {
+	tests := []struct {
+		name  string
+		sum   string
+		data  string
+		match bool
+	}{
+		{"sha256", "sha256:ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad", "abc", true},
+		{"crc32c", "crc32c:e3069283", "123456789", true},
+		{"uppercase hex", "crc32c:E3069283", "123456789", true},
+		{"mismatch", "crc32c:e3069283", "12345678", false},
+		{"unsupported algorithm", "md5:900150983cd24fb0d6963f7d28e17f72", "abc", false},
+		{"missing algorithm", "e3069283", "123456789", false},
+		{"empty", "", "", false},
+		{"empty hash", "crc32c:", "", false},
+	}
+	for _, v := range tests {
+		assert.Equal(t, v.match, verifyChecksum(v.sum, []byte(v.data)), v.name)
+	}
+}
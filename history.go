@@ -0,0 +1,177 @@
+package mego
+
+import (
+	"sync"
+	"time"
+
+	"github.com/vmihailenco/msgpack"
+)
+
+// History 呈現了頻道中一則已經廣播過的事件紀錄。
+type History struct {
+	// Sequence 是這則事件在頻道中的序號。
+	Sequence int
+	// Result 是這則事件以 MessagePack 編碼後的資料酬載，保存編碼後的內容能避免廣播後被修改的資料影響紀錄。
+	Result []byte
+	// CreatedAt 是這則事件被廣播的時間。
+	CreatedAt time.Time
+	// Exclude 是廣播時被排除在外的階段編號，例如略過自己的發布者，補收時也不會將這則事件送給該階段。
+	Exclude string
+}
+
+// HistoryStore 是頻道歷史事件的儲存介面，開發者能以此將歷史事件保存至資料庫等外部儲存裝置。
+type HistoryStore interface {
+	// Append 會將一則事件紀錄保存至指定事件與頻道的歷史中。
+	Append(event string, channel string, history History) error
+	// Since 會依序號排序回傳指定事件與頻道中，序號大於 `sequence` 的所有事件紀錄。
+	Since(event string, channel string, sequence int) ([]History, error)
+	// Trim 會移除超過保留數量或早於指定時間的事件紀錄。`length` 為 `0` 或 `before` 為零值時表示不限制。
+	Trim(event string, channel string, length int, before time.Time) error
+}
+
+// NewMemoryHistoryStore 會建立一個以記憶體保存歷史事件的儲存裝置。
+func NewMemoryHistoryStore() *MemoryHistoryStore {
+	return &MemoryHistoryStore{
+		histories: make(map[string][]History),
+	}
+}
+
+// MemoryHistoryStore 是以記憶體保存的頻道歷史事件儲存裝置，引擎重啟後紀錄就會消失。
+type MemoryHistoryStore struct {
+	// histories 以事件與頻道名稱作為鍵名存放歷史事件。
+	histories map[string][]History
+	// lock 是避免歷史事件同時被讀寫的讀寫鎖。
+	lock sync.RWMutex
+}
+
+// Append 會將一則事件紀錄保存至指定事件與頻道的歷史中。
+func (m *MemoryHistoryStore) Append(event string, channel string, history History) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	key := historyKey(event, channel)
+	m.histories[key] = append(m.histories[key], history)
+	return nil
+}
+
+// Since 會依序號排序回傳指定事件與頻道中，序號大於 `sequence` 的所有事件紀錄。
+func (m *MemoryHistoryStore) Since(event string, channel string, sequence int) ([]History, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	var list []History
+	for _, v := range m.histories[historyKey(event, channel)] {
+		if v.Sequence > sequence {
+			list = append(list, v)
+		}
+	}
+	return list, nil
+}
+
+// Trim 會移除超過保留數量或早於指定時間的事件紀錄。
+func (m *MemoryHistoryStore) Trim(event string, channel string, length int, before time.Time) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	key := historyKey(event, channel)
+	list := m.histories[key]
+	// 紀錄是依時間排序的，因此只要找到第一則沒有過期的紀錄即可。
+	if !before.IsZero() {
+		i := 0
+		for i < len(list) && list[i].CreatedAt.Before(before) {
+			i++
+		}
+		list = list[i:]
+	}
+	if length > 0 && len(list) > length {
+		list = list[len(list)-length:]
+	}
+	m.histories[key] = list
+	return nil
+}
+
+// historyKey 會以事件與頻道名稱組成歷史事件的鍵名。
+func historyKey(event string, channel string) string {
+	return event + "\x00" + channel
+}
+
+// historyStore 會回傳引擎所使用的歷史事件儲存裝置，沒有開啟歷史事件時會回傳 `nil`。
+func (e *Engine) historyStore() HistoryStore {
+	if e.Option.HistoryLength == 0 && e.Option.HistoryAge == 0 {
+		return nil
+	}
+	// 儲存裝置只會在第一次使用時建立，之後僅需要讀取鎖。
+	e.lock.RLock()
+	store := e.Option.HistoryStore
+	e.lock.RUnlock()
+	if store != nil {
+		return store
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.Option.HistoryStore == nil {
+		e.Option.HistoryStore = NewMemoryHistoryStore()
+	}
+	return e.Option.HistoryStore
+}
+
+// historyBefore 會依照歷史事件的保留秒數回傳過期的時間點，沒有限制時回傳零值。
+func (e *Engine) historyBefore() time.Time {
+	if e.Option.HistoryAge == 0 {
+		return time.Time{}
+	}
+	return e.clock().Now().Add(-time.Second * time.Duration(e.Option.HistoryAge))
+}
+
+// record 會將已廣播的事件保存至頻道的歷史紀錄中，`exclude` 是廣播時被排除在外的階段編號。
+func (e *Engine) record(event string, channel string, sequence int, result interface{}, exclude string) {
+	store := e.historyStore()
+	if store == nil {
+		return
+	}
+	b, err := msgpack.Marshal(result)
+	if err != nil {
+		return
+	}
+	store.Append(event, channel, History{
+		Sequence:  sequence,
+		Result:    b,
+		CreatedAt: e.clock().Now(),
+		Exclude:   exclude,
+	})
+	store.Trim(event, channel, e.Option.HistoryLength, e.historyBefore())
+}
+
+// replay 會將指定頻道中序號大於 `since` 的歷史事件依序重新傳送給指定階段。
+// 當 `until` 大於零時，僅會傳送序號小於 `until` 的事件。
+func (e *Engine) replay(sess *Session, event string, channel string, since int, until int) {
+	store := e.historyStore()
+	if store == nil {
+		return
+	}
+	// 補收期間持有頻道的傳送鎖，避免新發生的事件穿插在歷史事件之間而打亂序號的順序。
+	if ch, err := e.channel(event, channel); err == nil {
+		ch.sendLock.Lock()
+		defer ch.sendLock.Unlock()
+	}
+	store.Trim(event, channel, e.Option.HistoryLength, e.historyBefore())
+	list, err := store.Since(event, channel, since)
+	if err != nil {
+		return
+	}
+	for _, v := range list {
+		if until > 0 && v.Sequence >= until {
+			break
+		}
+		if v.Exclude != "" && v.Exclude == sess.ID {
+			continue
+		}
+		var result interface{}
+		if err := msgpack.Unmarshal(v.Result, &result); err != nil {
+			continue
+		}
+		sess.write(Response{
+			Event:    event,
+			Channel:  channel,
+			Sequence: v.Sequence,
+			Result:   result,
+		})
+	}
+}
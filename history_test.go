@@ -0,0 +1,141 @@
+package mego
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmihailenco/msgpack"
+)
+
+func TestMemoryHistoryStore(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		length int
+		before time.Time
+		expect []int
+	}{
+		{"unlimited", 0, time.Time{}, []int{1, 2, 3, 4}},
+		{"length", 2, time.Time{}, []int{3, 4}},
+		{"age", 0, start.Add(time.Second * 2), []int{3, 4}},
+		{"length and age", 1, start.Add(time.Second), []int{4}},
+		{"all expired", 0, start.Add(time.Hour), nil},
+	}
+	for _, v := range tests {
+		m := NewMemoryHistoryStore()
+		for i := 1; i <= 4; i++ {
+			m.Append("Event", "Channel", History{
+				Sequence:  i,
+				CreatedAt: start.Add(time.Second * time.Duration(i-1)),
+			})
+		}
+		m.Append("Event", "Other", History{Sequence: 1})
+		assert.NoError(t, m.Trim("Event", "Channel", v.length, v.before), v.name)
+		list, err := m.Since("Event", "Channel", 0)
+		assert.NoError(t, err, v.name)
+		var got []int
+		for _, h := range list {
+			got = append(got, h.Sequence)
+		}
+		assert.Equal(t, v.expect, got, v.name)
+
+		// 其他頻道的紀錄不受影響。
+		list, _ = m.Since("Event", "Other", 0)
+		assert.Len(t, list, 1, v.name)
+	}
+
+	m := NewMemoryHistoryStore()
+	for i := 1; i <= 4; i++ {
+		m.Append("Event", "Channel", History{Sequence: i})
+	}
+	list, _ := m.Since("Event", "Channel", 2)
+	assert.Len(t, list, 2)
+	assert.Equal(t, 3, list[0].Sequence)
+}
+
+func TestHistoryReplay(t *testing.T) {
+	e, sess, _ := newTestEngine()
+	clock := e.Option.Clock.(*FakeClock)
+	e.Option.HistoryLength = 3
+	e.Option.HistoryAge = 60
+	e.Event("Event").Channel("Channel")
+
+	for i := 1; i <= 4; i++ {
+		data := H{"Index": i}
+		assert.NoError(t, e.Emit("Event", "Channel", data))
+		// 廣播後修改資料不會影響歷史紀錄。
+		data["Index"] = 0
+		clock.Advance(time.Second * 20)
+	}
+
+	// 只會保留最近的三則，而超過保留秒數的紀錄也會被移除。
+	e.replay(sess, "Event", "Channel", 0, 0)
+	var sequences, indexes []int
+	for _, msg := range sess.queue {
+		var resp struct {
+			Sequence int `msgpack:"s"`
+			Result   struct {
+				Index int
+			} `msgpack:"r"`
+		}
+		assert.NoError(t, msgpack.Unmarshal(msg, &resp))
+		sequences = append(sequences, resp.Sequence)
+		indexes = append(indexes, resp.Result.Index)
+	}
+	assert.Equal(t, []int{2, 3, 4}, sequences)
+	assert.Equal(t, []int{2, 3, 4}, indexes)
+}
+
+func TestSubscribeReplay(t *testing.T) {
+	tests := []struct {
+		name   string
+		params []interface{}
+		replay int
+	}{
+		{"without sequence", []interface{}{"Event", "Channel"}, 0},
+		{"from zero", []interface{}{"Event", "Channel", 0}, 2},
+		{"from sequence", []interface{}{"Event", "Channel", 1}, 1},
+	}
+	for _, v := range tests {
+		e, sess, _ := newTestEngine()
+		e.Option.HistoryLength = 10
+		e.Event("Event").Channel("Channel")
+		assert.NoError(t, e.Emit("Event", "Channel", 1), v.name)
+		assert.NoError(t, e.Emit("Event", "Channel", 2), v.name)
+
+		e.subscribeRequest(&Context{
+			Session: sess,
+			data:    v.params,
+			engine:  e,
+		})
+		assert.Len(t, sess.queue, v.replay, v.name)
+	}
+}
+
+func TestHistoryReplayLock(t *testing.T) {
+	e, sess, _ := newTestEngine()
+	e.Option.HistoryLength = 10
+	e.Event("Event").Channel("Channel")
+	assert.NoError(t, e.Emit("Event", "Channel", 1))
+	ch, err := e.channel("Event", "Channel")
+	assert.NoError(t, err)
+
+	// 正在廣播時，補收必須等到廣播結束才會開始傳送歷史事件。
+	ch.sendLock.Lock()
+	done := make(chan struct{})
+	go func() {
+		e.replay(sess, "Event", "Channel", 0, 0)
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("replay did not wait for the channel send lock")
+	case <-time.After(time.Millisecond * 50):
+	}
+	ch.sendLock.Unlock()
+	<-done
+	sess.lock.Lock()
+	defer sess.lock.Unlock()
+	assert.Len(t, sess.queue, 1)
+}
@@ -0,0 +1,82 @@
+package mego
+
+import "time"
+
+var (
+	// DefaultJanitorInterval 是清潔工預設每隔幾秒清理一次遺留的上傳。
+	DefaultJanitorInterval = 60 * 10
+	// DefaultUploadMaxAge 是上傳預設最多能保留的秒數，不論是否仍在接收區塊。
+	DefaultUploadMaxAge = 60 * 60 * 24
+)
+
+// janitorInterval 會回傳清潔工每次清理的間隔。
+func (e *Engine) janitorInterval() time.Duration {
+	if e.Option.JanitorInterval == 0 {
+		return time.Second * time.Duration(DefaultJanitorInterval)
+	}
+	return time.Second * time.Duration(e.Option.JanitorInterval)
+}
+
+// uploadMaxAge 會回傳上傳最多能保留的時間。
+func (e *Engine) uploadMaxAge() time.Duration {
+	if e.Option.UploadMaxAge == 0 {
+		return time.Second * time.Duration(DefaultUploadMaxAge)
+	}
+	return time.Second * time.Duration(e.Option.UploadMaxAge)
+}
+
+// startJanitor 會在清潔工尚未運作時排定下一次的清理，呼叫前必須持有引擎的鎖。
+func (e *Engine) startJanitor() {
+	if e.janitor != nil {
+		return
+	}
+	e.janitor = e.clock().AfterFunc(e.janitorInterval(), e.sweep)
+}
+
+// stopJanitor 會停止清潔工。
+func (e *Engine) stopJanitor() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.janitor != nil {
+		e.janitor.Stop()
+		e.janitor = nil
+	}
+}
+
+// sweep 會中止超過保留時間的上傳，並讓檔案儲存裝置清除中斷而遺留的檔案，接著排定下一次的清理。
+// 所屬階段暫時不在的上傳不會被中止，讓客戶端能在重新連線後繼續上傳，閒置過久的上傳則會由其逾期計時器中止。
+func (e *Engine) sweep() {
+	now := e.clock().Now()
+	e.lock.RLock()
+	var expired []*upload
+	for _, u := range e.uploads {
+		if now.Sub(u.created) > e.uploadMaxAge() {
+			expired = append(expired, u)
+		}
+	}
+	e.lock.RUnlock()
+
+	for _, u := range expired {
+		e.abortUpload(u)
+	}
+	if v, ok := e.fileStore().(FileSweeper); ok {
+		v.Sweep(now.Add(-e.uploadMaxAge()))
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.janitor != nil {
+		e.janitor = e.clock().AfterFunc(e.janitorInterval(), e.sweep)
+	}
+}
+
+// cleanFiles 會在請求結束後移除沒有被處理函式保留、移動或移除的上傳檔案。
+func (e *Engine) cleanFiles(c *Context) {
+	for _, files := range c.files {
+		for _, f := range files {
+			if !f.claimed {
+				f.Remove()
+			}
+		}
+	}
+}
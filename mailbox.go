@@ -0,0 +1,473 @@
+package mego
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/vmihailenco/msgpack"
+)
+
+// Mail 呈現了信箱中一則尚未被客戶端確認收到的事件。
+type Mail struct {
+	// ID 是這則事件的投遞編號，客戶端會以此編號回報已經收到。
+	ID string
+	// Event 是事件名稱。
+	Event string
+	// Channel 是廣播此事件的頻道名稱，不是透過頻道廣播的事件則為空字串。
+	Channel string
+	// Result 是這則事件以 MessagePack 編碼後的資料酬載。
+	Result []byte
+	// CreatedAt 是這則事件被放入信箱的時間。
+	CreatedAt time.Time
+	// Received 是已經確認收到這則事件的階段編號。使用者的信箱會讓每個裝置各自收到一次，因此只記錄收到的階段而不移除事件。
+	Received []string
+}
+
+// MailboxStore 是離線信箱的儲存介面，開發者能以此將尚未送達的事件保存至資料庫等外部儲存裝置。
+// 信箱的擁有者名稱以 `user:` 或 `session:` 作為前綴，分別表示使用者與階段的信箱。
+type MailboxStore interface {
+	// Push 會將一則事件放入指定擁有者的信箱。
+	Push(owner string, mail Mail) error
+	// List 會依放入的順序回傳指定擁有者信箱中的所有事件。
+	List(owner string) ([]Mail, error)
+	// Remove 會將指定編號的事件從信箱中移除，事件不存在時不會回傳錯誤。
+	Remove(owner string, id string) error
+	// Acknowledge 會將指定階段記錄至指定編號事件的 `Received` 中，事件不存在時不會回傳錯誤。
+	Acknowledge(owner string, id string, session string) error
+	// Trim 會移除超過保留數量或早於指定時間的事件。`length` 為 `0` 或 `before` 為零值時表示不限制。
+	Trim(owner string, length int, before time.Time) error
+}
+
+// NewMemoryMailboxStore 會建立一個以記憶體保存離線事件的信箱。
+func NewMemoryMailboxStore() *MemoryMailboxStore {
+	return &MemoryMailboxStore{
+		mailboxes: make(map[string][]Mail),
+	}
+}
+
+// MemoryMailboxStore 是以記憶體保存的離線信箱，引擎重啟後事件就會消失。
+type MemoryMailboxStore struct {
+	// mailboxes 以擁有者名稱作為鍵名存放信箱中的事件。
+	mailboxes map[string][]Mail
+	// lock 是避免信箱同時被讀寫的讀寫鎖。
+	lock sync.RWMutex
+}
+
+// Push 會將一則事件放入指定擁有者的信箱。
+func (m *MemoryMailboxStore) Push(owner string, mail Mail) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.mailboxes[owner] = append(m.mailboxes[owner], mail)
+	return nil
+}
+
+// List 會依放入的順序回傳指定擁有者信箱中的所有事件。
+func (m *MemoryMailboxStore) List(owner string) ([]Mail, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	list := make([]Mail, len(m.mailboxes[owner]))
+	copy(list, m.mailboxes[owner])
+	return list, nil
+}
+
+// Remove 會將指定編號的事件從信箱中移除。
+func (m *MemoryMailboxStore) Remove(owner string, id string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.set(owner, removeMail(m.mailboxes[owner], id))
+	return nil
+}
+
+// Acknowledge 會記錄指定階段已經收到指定編號的事件。
+func (m *MemoryMailboxStore) Acknowledge(owner string, id string, session string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.set(owner, receiveMail(m.mailboxes[owner], id, session))
+	return nil
+}
+
+// Trim 會移除超過保留數量或早於指定時間的事件。
+func (m *MemoryMailboxStore) Trim(owner string, length int, before time.Time) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.set(owner, trimMail(m.mailboxes[owner], length, before))
+	return nil
+}
+
+// set 會更新指定擁有者的信箱，空的信箱會被移除以免佔用記憶體。
+func (m *MemoryMailboxStore) set(owner string, list []Mail) {
+	if len(list) == 0 {
+		delete(m.mailboxes, owner)
+		return
+	}
+	m.mailboxes[owner] = list
+}
+
+// NewFileMailboxStore 會建立一個將離線事件保存在指定資料夾中的信箱，資料夾不存在時會自動建立。
+func NewFileMailboxStore(dir string) (*FileMailboxStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileMailboxStore{
+		Dir: dir,
+	}, nil
+}
+
+// FileMailboxStore 是以檔案保存的離線信箱，每個擁有者的信箱都是資料夾中的一個檔案，引擎重啟後事件仍會保留。
+type FileMailboxStore struct {
+	// Dir 是存放信箱檔案的資料夾路徑。
+	Dir string
+	// lock 是避免信箱檔案同時被讀寫的讀寫鎖。
+	lock sync.RWMutex
+}
+
+// Push 會將一則事件放入指定擁有者的信箱。
+func (f *FileMailboxStore) Push(owner string, mail Mail) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	list, err := f.read(owner)
+	if err != nil {
+		return err
+	}
+	return f.write(owner, append(list, mail))
+}
+
+// List 會依放入的順序回傳指定擁有者信箱中的所有事件。
+func (f *FileMailboxStore) List(owner string) ([]Mail, error) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return f.read(owner)
+}
+
+// Remove 會將指定編號的事件從信箱中移除。
+func (f *FileMailboxStore) Remove(owner string, id string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	list, err := f.read(owner)
+	if err != nil {
+		return err
+	}
+	return f.write(owner, removeMail(list, id))
+}
+
+// Acknowledge 會記錄指定階段已經收到指定編號的事件。
+func (f *FileMailboxStore) Acknowledge(owner string, id string, session string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	list, err := f.read(owner)
+	if err != nil {
+		return err
+	}
+	return f.write(owner, receiveMail(list, id, session))
+}
+
+// Trim 會移除超過保留數量或早於指定時間的事件。
+func (f *FileMailboxStore) Trim(owner string, length int, before time.Time) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	list, err := f.read(owner)
+	if err != nil {
+		return err
+	}
+	return f.write(owner, trimMail(list, length, before))
+}
+
+// path 會回傳指定擁有者的信箱檔案路徑，擁有者名稱會被編碼以避免產生不合法的檔案名稱。
+func (f *FileMailboxStore) path(owner string) string {
+	return filepath.Join(f.Dir, hex.EncodeToString([]byte(owner))+".mailbox")
+}
+
+// read 會讀取指定擁有者的信箱檔案，檔案不存在時表示信箱是空的。
+func (f *FileMailboxStore) read(owner string) ([]Mail, error) {
+	b, err := ioutil.ReadFile(f.path(owner))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []Mail
+	if err := msgpack.Unmarshal(b, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// write 會將信箱寫入指定擁有者的檔案，空的信箱則會刪除其檔案。
+// 內容會先寫入暫存檔再重新命名，避免寫入途中中斷而損毀原本的信箱。
+func (f *FileMailboxStore) write(owner string, list []Mail) error {
+	path := f.path(owner)
+	if len(list) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	b, err := msgpack.Marshal(list)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path+".tmp", b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// removeMail 會回傳移除指定編號事件後的信箱。
+func removeMail(list []Mail, id string) []Mail {
+	for i, v := range list {
+		if v.ID == id {
+			return append(list[:i:i], list[i+1:]...)
+		}
+	}
+	return list
+}
+
+// receiveMail 會回傳將指定階段記錄為已收到指定編號事件後的信箱。
+// `List` 回傳的事件可能與信箱共用底層陣列，因此會複製一份新的信箱而不會修改原本的內容。
+func receiveMail(list []Mail, id string, session string) []Mail {
+	for i, v := range list {
+		if v.ID != id || v.received(session) {
+			continue
+		}
+		next := make([]Mail, len(list))
+		copy(next, list)
+		next[i].Received = append(v.Received[:len(v.Received):len(v.Received)], session)
+		return next
+	}
+	return list
+}
+
+// received 會回傳指定階段是否已經確認收到這則事件。
+func (m Mail) received(session string) bool {
+	for _, v := range m.Received {
+		if v == session {
+			return true
+		}
+	}
+	return false
+}
+
+// trimMail 會回傳移除超過保留數量或早於指定時間的事件後的信箱。
+func trimMail(list []Mail, length int, before time.Time) []Mail {
+	// 事件是依時間排序的，因此只要找到第一則沒有過期的事件即可。
+	if !before.IsZero() {
+		i := 0
+		for i < len(list) && list[i].CreatedAt.Before(before) {
+			i++
+		}
+		list = list[i:]
+	}
+	if length > 0 && len(list) > length {
+		list = list[len(list)-length:]
+	}
+	return list
+}
+
+// userMailbox 會回傳指定使用者的信箱名稱。
+func userMailbox(id string) string {
+	return "user:" + id
+}
+
+// sessionMailbox 會回傳指定階段的信箱名稱。
+func sessionMailbox(id string) string {
+	return "session:" + id
+}
+
+// mailboxStore 會回傳引擎所使用的離線信箱儲存裝置。當 `create` 為 `true` 時會在未指定儲存裝置時使用記憶體儲存，
+// 否則會回傳 `nil`，表示從未有事件被放入信箱。
+func (e *Engine) mailboxStore(create bool) MailboxStore {
+	// 儲存裝置只會在第一次使用時建立，之後僅需要讀取鎖。
+	e.lock.RLock()
+	store := e.Option.MailboxStore
+	e.lock.RUnlock()
+	if store != nil || !create {
+		return store
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.Option.MailboxStore == nil && create {
+		e.Option.MailboxStore = NewMemoryMailboxStore()
+	}
+	return e.Option.MailboxStore
+}
+
+// mailboxBefore 會依照信箱的保留秒數回傳過期的時間點，沒有限制時回傳零值。
+func (e *Engine) mailboxBefore() time.Time {
+	if e.Option.MailboxAge == 0 {
+		return time.Time{}
+	}
+	return e.clock().Now().Add(-time.Second * time.Duration(e.Option.MailboxAge))
+}
+
+// post 會將事件放入指定的信箱，並送給目前在線上的階段。階段信箱中的事件會保留到客戶端確認收到為止，
+// 使用者信箱中的事件則會保留到超過保留數量或秒數，讓使用者的每個裝置都能各自收到一次。
+func (e *Engine) post(owner string, sessions []*Session, event string, channel string, result interface{}) error {
+	store := e.mailboxStore(true)
+	b, err := msgpack.Marshal(result)
+	if err != nil {
+		return err
+	}
+	mail := Mail{
+		ID:        uuid.NewV4().String(),
+		Event:     event,
+		Channel:   channel,
+		Result:    b,
+		CreatedAt: e.clock().Now(),
+	}
+	if err := store.Push(owner, mail); err != nil {
+		return err
+	}
+	if err := store.Trim(owner, e.Option.MailboxLength, e.mailboxBefore()); err != nil {
+		return err
+	}
+	if len(sessions) == 0 {
+		return nil
+	}
+	msg, err := msgpack.Marshal(Response{
+		Event:    event,
+		Channel:  channel,
+		Result:   result,
+		Delivery: mail.ID,
+	})
+	if err != nil {
+		return err
+	}
+	for _, v := range sessions {
+		v.writeBinary(msg)
+	}
+	return nil
+}
+
+// postMembers 會將頻道事件放入沒有任何階段正在接收此事件之成員的使用者信箱，事件沒有開啟離線信箱時不做任何事。
+func (e *Engine) postMembers(ch *Channel, recipients []*Session, event string, channel string, result interface{}) error {
+	if !e.eventOption(event).Mailbox {
+		return nil
+	}
+	online := make(map[string]bool)
+	for _, v := range recipients {
+		if user := v.User(); user != "" {
+			online[user] = true
+		}
+	}
+	for _, user := range ch.offlineMembers(online) {
+		if err := e.post(userMailbox(user), nil, event, channel, result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// join 會將使用者記錄為此頻道的成員，成員離線時頻道中的事件會被放入其使用者信箱。
+func (c *Channel) join(user string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.members == nil {
+		c.members = make(map[string]bool)
+	}
+	c.members[user] = true
+}
+
+// leave 會在使用者已經沒有任何階段訂閱此頻道時將其從成員中移除，呼叫前必須持有引擎的鎖。
+func (c *Channel) leave(user string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, v := range c.Sessions {
+		if v.origin != nil {
+			v = v.origin
+		}
+		if v.user == user {
+			return
+		}
+	}
+	delete(c.members, user)
+}
+
+// offlineMembers 會回傳不在傳入的在線使用者中的成員。
+func (c *Channel) offlineMembers(online map[string]bool) []string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	var list []string
+	for user := range c.members {
+		if !online[user] {
+			list = append(list, user)
+		}
+	}
+	sort.Strings(list)
+	return list
+}
+
+// deliver 會依序將指定信箱中尚未確認收到的事件送給傳入的階段。
+func (e *Engine) deliver(sess *Session, owner string) {
+	store := e.mailboxStore(false)
+	if store == nil {
+		return
+	}
+	store.Trim(owner, e.Option.MailboxLength, e.mailboxBefore())
+	list, err := store.List(owner)
+	if err != nil {
+		return
+	}
+	for _, v := range list {
+		if v.received(sess.ID) {
+			continue
+		}
+		var result interface{}
+		if err := msgpack.Unmarshal(v.Result, &result); err != nil {
+			continue
+		}
+		sess.write(Response{
+			Event:    v.Event,
+			Channel:  v.Channel,
+			Result:   result,
+			Delivery: v.ID,
+		})
+	}
+}
+
+// acknowledge 會將客戶端確認收到的事件從其階段的信箱中移除，並在使用者的信箱中記錄此階段已經收到，
+// 如此一來使用者其他尚未收到的裝置仍會在登入時收到。同時也會停止重新傳送該投遞給此階段。
+func (e *Engine) acknowledge(sess *Session, id string) {
+	if id == "" {
+		return
+	}
+	sess.settle(id)
+	store := e.mailboxStore(false)
+	if store == nil {
+		return
+	}
+	store.Remove(sessionMailbox(sess.ID), id)
+	if user := sess.User(); user != "" {
+		store.Acknowledge(userMailbox(user), id, sess.ID)
+	}
+}
+
+// EmitToSession 會向指定編號的階段廣播一個事件，此事件不需要事先訂閱。
+// 如果事件有開啟離線信箱，階段不在線上時事件會被保留，並在相同編號的客戶端重新連線時送達。
+func (e *Engine) EmitToSession(id string, event string, result interface{}) error {
+	e.lock.RLock()
+	sess, ok := e.Sessions[id]
+	e.lock.RUnlock()
+
+	option := e.eventOption(event)
+	if option.Mailbox {
+		var sessions []*Session
+		if ok {
+			sessions = []*Session{sess}
+		}
+		return e.post(sessionMailbox(id), sessions, event, "", result)
+	}
+	if !ok {
+		return ErrSessionNotFound
+	}
+	return e.send([]*Session{sess}, Response{
+		Event:  event,
+		Result: result,
+	}, option.Acknowledge)
+}
@@ -0,0 +1,198 @@
+package mego
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmihailenco/msgpack"
+)
+
+func TestTrimMail(t *testing.T) {
+	now := time.Now()
+	list := []Mail{
+		{ID: "a", CreatedAt: now.Add(-3 * time.Second)},
+		{ID: "b", CreatedAt: now.Add(-2 * time.Second)},
+		{ID: "c", CreatedAt: now.Add(-time.Second)},
+		{ID: "d", CreatedAt: now},
+	}
+	tests := []struct {
+		name   string
+		length int
+		before time.Time
+		ids    []string
+	}{
+		{"unlimited", 0, time.Time{}, []string{"a", "b", "c", "d"}},
+		{"length", 2, time.Time{}, []string{"c", "d"}},
+		{"length larger than list", 10, time.Time{}, []string{"a", "b", "c", "d"}},
+		{"age", 0, now.Add(-2 * time.Second), []string{"b", "c", "d"}},
+		{"age and length", 1, now.Add(-2 * time.Second), []string{"d"}},
+		{"all expired", 0, now.Add(time.Second), nil},
+	}
+	for _, v := range tests {
+		var ids []string
+		for _, m := range trimMail(list, v.length, v.before) {
+			ids = append(ids, m.ID)
+		}
+		assert.Equal(t, v.ids, ids, v.name)
+	}
+}
+
+func TestMailboxStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mego-mailbox")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file, err := NewFileMailboxStore(dir)
+	assert.NoError(t, err)
+
+	now := time.Now()
+	for name, store := range map[string]MailboxStore{
+		"memory": NewMemoryMailboxStore(),
+		"file":   file,
+	} {
+		for i, id := range []string{"a", "b", "c"} {
+			assert.NoError(t, store.Push("owner", Mail{ID: id, CreatedAt: now.Add(time.Duration(i) * time.Second)}), name)
+		}
+		assert.NoError(t, store.Remove("owner", "b"), name)
+		assert.NoError(t, store.Trim("owner", 0, now.Add(time.Second)), name)
+		list, err := store.List("owner")
+		assert.NoError(t, err, name)
+		assert.Len(t, list, 1, name)
+		assert.Equal(t, "c", list[0].ID, name)
+
+		// 確認收到的階段只會被記錄一次，且不會影響先前取得的事件。
+		assert.NoError(t, store.Acknowledge("owner", "c", "session"), name)
+		assert.NoError(t, store.Acknowledge("owner", "c", "session"), name)
+		assert.NoError(t, store.Acknowledge("owner", "missing", "session"), name)
+		assert.Len(t, list[0].Received, 0, name)
+		list, err = store.List("owner")
+		assert.NoError(t, err, name)
+		assert.Equal(t, []string{"session"}, list[0].Received, name)
+
+		// 沒有任何事件的信箱會是空的。
+		list, err = store.List("empty")
+		assert.NoError(t, err, name)
+		assert.Len(t, list, 0, name)
+	}
+}
+
+// mails 會解讀階段佇列中的所有事件。
+func mails(t *testing.T, sess *Session) (list []Response) {
+	for _, msg := range sess.queue {
+		var resp Response
+		assert.NoError(t, msgpack.Unmarshal(msg, &resp))
+		list = append(list, resp)
+	}
+	return
+}
+
+func TestMailbox(t *testing.T) {
+	e, sess, _ := newTestEngine()
+	e.Option.MailboxAge = 10
+	e.Event("Notice", EventOption{
+		Mailbox: true,
+	})
+
+	// 不在線上的階段仍能收到信箱中的事件，超過保留秒數的事件則會被移除。
+	assert.NoError(t, e.EmitToSession("offline", "Notice", 1))
+	e.Option.Clock.(*FakeClock).Advance(11 * time.Second)
+	assert.NoError(t, e.EmitToSession("offline", "Notice", 2))
+
+	offline := &Session{
+		ID:       "offline",
+		engine:   e,
+		detached: true,
+	}
+	e.deliver(offline, sessionMailbox(offline.ID))
+	list := mails(t, offline)
+	assert.Len(t, list, 1)
+	assert.EqualValues(t, 2, list[0].Result)
+	assert.NotEmpty(t, list[0].Delivery)
+
+	// 確認收到後的事件就不會再次送達。
+	e.acknowledge(offline, list[0].Delivery)
+	offline.queue = nil
+	e.deliver(offline, sessionMailbox(offline.ID))
+	assert.Len(t, offline.queue, 0)
+
+	// 在線上的階段會立即收到事件。
+	assert.NoError(t, e.EmitToSession(sess.ID, "Notice", 3))
+	assert.Len(t, sess.queue, 1)
+}
+
+func TestUserMailboxDevices(t *testing.T) {
+	e, _, _ := newTestEngine()
+	e.Event("Notice", EventOption{
+		Mailbox: true,
+	})
+	assert.NoError(t, e.EmitToUser("user", "Notice", 1))
+
+	device := func(id string) *Session {
+		sess := &Session{
+			ID:       id,
+			engine:   e,
+			detached: true,
+		}
+		e.Sessions[id] = sess
+		return sess
+	}
+
+	// 一個裝置確認收到後，使用者的其他裝置仍會在登入時收到。
+	phone := device("phone")
+	phone.SetUser("user")
+	list := mails(t, phone)
+	assert.Len(t, list, 1)
+	e.acknowledge(phone, list[0].Delivery)
+
+	laptop := device("laptop")
+	laptop.SetUser("user")
+	assert.Len(t, mails(t, laptop), 1)
+
+	// 已經確認收到的裝置再次登入時則不會重複收到。
+	phone.queue = nil
+	phone.SetUser("")
+	phone.SetUser("user")
+	assert.Len(t, phone.queue, 0)
+}
+
+func TestChannelMailbox(t *testing.T) {
+	e, _, _ := newTestEngine()
+	e.Event("Room", EventOption{
+		Mailbox: true,
+	})
+	join := func(id string, user string) *Session {
+		sess := &Session{
+			ID:       id,
+			engine:   e,
+			detached: true,
+		}
+		e.Sessions[id] = sess
+		sess.SetUser(user)
+		assert.NoError(t, e.subscribe(sess, "Room", "1", nil, false))
+		return sess
+	}
+	a := join("a", "alice")
+	b := join("b", "bob")
+
+	// 離線的成員會在其使用者信箱中收到頻道事件，在線上的成員則直接收到。
+	e.removeSession(a)
+	assert.NoError(t, e.Emit("Room", "1", 1))
+	assert.Len(t, mails(t, b), 1)
+
+	a = join("a2", "alice")
+	list := mails(t, a)
+	assert.Len(t, list, 1)
+	assert.Equal(t, "1", list[0].Channel)
+	assert.EqualValues(t, 1, list[0].Result)
+	assert.NotEmpty(t, list[0].Delivery)
+
+	// 明確取消訂閱的使用者就不再是成員，離線後也不會收到。
+	e.unsubscribe(a, "Room", "1")
+	e.removeSession(a)
+	assert.NoError(t, e.Emit("Room", "1", 2))
+	inbox, err := e.mailboxStore(false).List(userMailbox("alice"))
+	assert.NoError(t, err)
+	assert.Len(t, inbox, 1)
+}
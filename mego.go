@@ -3,11 +3,11 @@ package mego
 import (
 	"fmt"
 	"io"
-	"io/ioutil"
 	"net/http"
 	"os"
-	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
 	mirror "github.com/TeaMeow/Mirror"
 	uuid "github.com/satori/go.uuid"
@@ -38,6 +38,8 @@ const (
 	ChunkDone
 	// ChunkAbort 表示不打算處理本檔案了，結束此檔案的處理手續並停止上傳。
 	ChunkAbort
+	// ChunkRetry 表示區塊內容有誤，會以 `StatusFileRetry` 要求客戶端重新傳送。
+	ChunkRetry
 )
 
 const (
@@ -88,7 +90,9 @@ func New() *Engine {
 		Sessions:     make(map[string]*Session),
 		Events:       make(map[string]*Event),
 		Methods:      make(map[string]*Method),
+		Option:       &EngineOption{},
 		chunkHandler: chunkHandler,
+		patterns:     newPatternNode(),
 	}
 }
 
@@ -127,24 +131,93 @@ type Engine struct {
 	server *server
 	// subscribeHandler 是處理所有事件訂閱的函式。
 	subscribeHandler SubscribeHandler
+	// publishHandler 是處理所有客戶端發布事件的函式。
+	publishHandler PublishHandler
+	// onDisconnect 是階段被移除時所會呼叫的函式。
+	onDisconnect func(*Session)
+	// ackFailureHandler 是事件沒有被客戶端確認收到時所呼叫的處理函式。
+	ackFailureHandler AckFailureHandler
 	// chunkHandler 是預設的方法區塊處理回呼函式，能被各個方法獨立覆蓋。
 	chunkHandler ChunkHandler
+	// uploadProgressHandler 是每次接收到區塊時所呼叫的上傳進度處理函式。
+	uploadProgressHandler UploadProgressHandler
+	// fileProcessors 是全域的檔案處理函式，會在呼叫方法處理函式前依序處理每個檔案。
+	fileProcessors []FileProcessor
+	// downloads 是正在傳送給客戶端的檔案下載，以下載編號作為鍵名。
+	downloads map[string]*download
+	// users 是以使用者編號作為鍵名的階段索引，一個使用者可以同時有多個裝置的階段。
+	users map[string][]*Session
+	// patterns 是以萬用字元樣式訂閱的事件與頻道索引。
+	patterns *patternNode
+	// jobs 是尚未執行完畢的排程工作，以工作編號作為鍵名。
+	jobs map[string]*Job
+	// uploads 是正在接收區塊的上傳，以上傳編號作為鍵名。
+	uploads map[string]*upload
+	// janitor 是清潔工下一次清理的計時器，清潔工尚未運作時為 `nil`。
+	janitor Timer
+	// lock 是避免多個連線同時存取階段與事件清單而發生資料競爭的讀寫鎖。
+	lock sync.RWMutex
 }
 
 // EngineOption 是引擎的選項設置。
 type EngineOption struct {
-	// MaxSize 是這個方法允許接收的最大位元組（Bytes）。
+	// MaxSize 是這個方法允許接收的最大位元組（Bytes），超過的訊息會被拒絕。`0` 表示無上限。
 	MaxSize int
-	// MaxChunkSize 是這個方法允許的區塊最大位元組（Bytes）。
+	// MaxChunkSize 是這個方法允許的區塊最大位元組（Bytes）。`0` 表示無上限。
 	MaxChunkSize int
-	// MaxFileSize 是這個方法允許的檔案最大位元組（Bytes），會在每次接收區塊時結算總計大小，
-	// 如果超過此大小則停止接收檔案。
+	// MaxFileSize 是這個方法允許的檔案最大位元組（Bytes），區塊上傳會以客戶端告知的檔案大小在接收第一個區塊前就先行檢查，
+	// 如果超過此大小則停止接收檔案。`0` 表示使用 `DefaultMaxFileSize`。
 	MaxFileSize int
 	// MaxSessions 是引擎能容忍的最大階段連線數量。
 	MaxSessions int
 	// CheckInterval 是每隔幾秒進行一次階段是否仍存在的連線檢查，
 	// 此為輕量檢查而非發送回應至客戶端。
 	CheckInterval int
+	// ResumeTimeout 是階段斷線後仍保留幾秒供客戶端以恢復權杖重新接回，
+	// 期間內該階段的訂閱與鍵值組都會被保留。`0` 表示不保留，斷線即移除階段。
+	ResumeTimeout int
+	// ResumeQueueSize 是階段斷線期間最多能暫存幾個待送事件，超過時會捨棄最舊的事件。`0` 表示無上限。
+	ResumeQueueSize int
+	// StrictEvents 表示客戶端僅能訂閱已經透過 `Event` 宣告的事件，訂閱其他事件會被拒絕。
+	StrictEvents bool
+	// Presence 表示是否在客戶端訂閱、取消訂閱或斷線時，
+	// 自動向同頻道的其他訂閱者廣播 `MegoJoin` 與 `MegoLeave` 事件。
+	Presence bool
+	// HistoryLength 是每個頻道最多保留幾則歷史事件供新訂閱者補收。
+	HistoryLength int
+	// HistoryAge 是頻道歷史事件的保留秒數，超過此時間的事件將不會被補收。
+	// 此選項與 `HistoryLength` 皆為 `0` 時表示不保留歷史事件。
+	HistoryAge int
+	// HistoryStore 是保存頻道歷史事件的儲存裝置，未指定時會使用記憶體儲存。
+	HistoryStore HistoryStore
+	// MailboxLength 是每個離線信箱最多保留的事件數量，超過時會捨棄最舊的事件。`0` 表示無上限。
+	MailboxLength int
+	// MailboxAge 是離線信箱中的事件最多保留幾秒，`0` 表示無上限。
+	MailboxAge int
+	// MailboxStore 是保存離線信箱的儲存裝置，未指定時會使用記憶體儲存。
+	MailboxStore MailboxStore
+	// AckTimeout 是等待客戶端確認收到事件的秒數，每次重新傳送後等待的時間都會加倍。`0` 表示使用 `DefaultAckTimeout`。
+	AckTimeout int
+	// AckRetries 是客戶端沒有確認收到時最多重新傳送事件的次數。`0` 表示使用 `DefaultAckRetries`。
+	AckRetries int
+	// FileStore 是存放上傳檔案的儲存裝置，未指定時會存放在系統暫存資料夾中的 `mego` 資料夾。
+	FileStore FileStore
+	// UploadExpiry 是區塊上傳中斷後保留幾秒供客戶端繼續上傳，逾期後已經接收的區塊會被移除。`0` 表示使用 `DefaultUploadExpiry`。
+	UploadExpiry int
+	// UploadMaxAge 是上傳最多能保留幾秒，超過的上傳不論是否仍在接收區塊都會被清潔工移除。`0` 表示使用 `DefaultUploadMaxAge`。
+	UploadMaxAge int
+	// JanitorInterval 是清潔工每隔幾秒清理一次遺留的上傳。`0` 表示使用 `DefaultJanitorInterval`。
+	JanitorInterval int
+	// ProcessTimeout 是檔案處理函式最多能執行幾秒，逾時的請求會以 `StatusTimeout` 終止。`0` 表示使用 `DefaultProcessTimeout`。
+	ProcessTimeout int
+	// DownloadChunkSize 是檔案下載時每個區塊的位元組大小。`0` 表示使用 `DefaultDownloadChunkSize`。
+	DownloadChunkSize int
+	// DownloadWindow 是檔案下載時最多有幾個尚未被客戶端確認的區塊。`0` 表示使用 `DefaultDownloadWindow`。
+	DownloadWindow int
+	// DownloadTimeout 是檔案下載時等待客戶端確認的秒數，逾時就會停止傳送。`0` 表示使用 `DefaultDownloadTimeout`。
+	DownloadTimeout int
+	// Clock 是引擎用來計時的時鐘，排程工作與重新傳送都會以此計時。未指定時使用系統時間，測試時能傳入 `FakeClock`。
+	Clock Clock
 }
 
 // Method 呈現了一個方法。
@@ -157,6 +230,8 @@ type Method struct {
 	Option *MethodOption
 	// ChunkHandler 是本方法的區塊處理回呼函式。
 	ChunkHandler ChunkHandler
+	// FileProcessors 是本方法的檔案處理函式，會在全域的檔案處理函式之後執行。
+	FileProcessors []FileProcessor
 }
 
 // MethodOption 是一個方法的選項。
@@ -165,9 +240,13 @@ type MethodOption struct {
 	MaxSize int
 	// MaxChunkSize 是這個方法允許的區塊最大位元組（Bytes）。此選項會覆蓋引擎設定。
 	MaxChunkSize int
-	// MaxFileSize 是這個方法允許的檔案最大位元組（Bytes），會在每次接收區塊時結算總計大小，
+	// MaxFileSize 是這個方法允許的檔案最大位元組（Bytes），區塊上傳會以客戶端告知的檔案大小在接收第一個區塊前就先行檢查，
 	// 如果超過此大小則停止接收檔案。此選項會覆蓋引擎設定。
 	MaxFileSize int
+	// Files 是各個檔案欄位的上傳限制，以檔案欄位名稱作為鍵名，沒有設置的檔案欄位則不受限制。
+	Files map[string]FileOption
+	// ProcessTimeout 是檔案處理函式最多能執行幾秒。此選項會覆蓋引擎設定。
+	ProcessTimeout int
 }
 
 // Run 會在指定的埠口執行 Mego 引擎。
@@ -189,6 +268,10 @@ func (e *Engine) Run(port ...string) {
 	m.HandleMessage(e.messageHandler)
 	// 將所有斷線的請求轉交給斷線處理函式。
 	m.HandleDisconnect(e.disconnectHandler)
+	// 開始定期清理遺留的上傳。
+	e.lock.Lock()
+	e.startJanitor()
+	e.lock.Unlock()
 	fmt.Println("Running...")
 	// 開始在指定埠口監聽 HTTP 請求並交由底層伺服器處理。
 	http.ListenAndServe(p, e.server)
@@ -196,44 +279,192 @@ func (e *Engine) Run(port ...string) {
 
 // disconnectHandler 會處理斷開連線的 WebSocket。
 func (e *Engine) disconnectHandler(s *melody.Session) {
-	// 如果客戶端離線了就自動移除他所監聽的事件和所有 Sessions
+	id, ok := s.Get("MegoID")
+	if !ok {
+		return
+	}
+	e.lock.RLock()
+	sess, ok := e.Sessions[id.(string)]
+	e.lock.RUnlock()
+	if !ok {
+		return
+	}
+	// 如果有設置恢復期限，就先保留這個階段與其訂閱，等待客戶端重新連線。
+	if e.Option.ResumeTimeout > 0 {
+		sess.detach(s, time.Second*time.Duration(e.Option.ResumeTimeout))
+		return
+	}
+	// 如果客戶端離線了就自動移除他所監聽的事件和所有 Sessions。
+	if sess.owns(s) {
+		e.removeSession(sess)
+	}
+}
+
+// initialize 會替新的 WebSocket 連線建立 Mego 階段。如果客戶端夾帶的恢復權杖符合仍在恢復期限內的舊階段，
+// 則會直接接回該階段並送出斷線期間所暫存的事件。
+func (e *Engine) initialize(s *melody.Session, id string, keys map[string]interface{}) {
+	// 恢復權杖不應該被當作客戶端的鍵值組保存。
+	token, _ := keys["MegoResumeToken"].(string)
+	delete(keys, "MegoResumeToken")
+
+	// 在底層階段存放此階段的編號。
+	s.Set("MegoID", id)
+
+	e.lock.RLock()
+	sess, ok := e.Sessions[id]
+	e.lock.RUnlock()
+
+	// 權杖相符就接回舊的階段，其頻道訂閱與鍵值組都會維持原樣。
+	if ok && token != "" && sess.resume(s, token) {
+		return
+	}
+	// 無法接回的同編號階段則先完整移除，避免殘留的訂閱。
+	if ok {
+		e.removeSession(sess)
+	}
+
+	if keys == nil {
+		keys = make(map[string]interface{})
+	}
+	sess = &Session{
+		ID:        id,
+		Keys:      keys,
+		engine:    e,
+		websocket: s,
+	}
+	// 有設置恢復期限時才核發恢復權杖給客戶端。
+	if e.Option.ResumeTimeout > 0 {
+		sess.resumeToken = uuid.NewV4().String()
+	}
+
+	// 將 Mego 階段放入引擎中保存。
+	e.lock.Lock()
+	e.Sessions[id] = sess
+	e.lock.Unlock()
+
+	if sess.resumeToken != "" {
+		sess.write(Response{
+			Event: "MegoResume",
+			Result: H{
+				"Token":   sess.resumeToken,
+				"Resumed": false,
+			},
+		})
+	}
+	// 依序送出此階段離線期間被放入信箱的事件。
+	e.deliver(sess, sessionMailbox(id))
+}
+
+// removeSession 會將指定階段從引擎與所有頻道中移除。
+func (e *Engine) removeSession(sess *Session) {
+	sess.stopTimer()
+	sess.stopDeliveries()
+
+	e.lock.Lock()
+	// 只有在引擎中仍是同一個階段時才移除，避免誤刪同編號的新階段。
+	v, removed := e.Sessions[sess.ID]
+	if removed = removed && v == sess; removed {
+		delete(e.Sessions, sess.ID)
+	}
+	e.unbindUser(sess)
+	e.lock.Unlock()
+
+	e.lock.RLock()
+	for _, evt := range e.Events {
+		for _, ch := range evt.Channels {
+			if presence, ok := ch.remove(sess); ok {
+				ch.announce("MegoLeave", sess, presence)
+			}
+		}
+	}
+	e.lock.RUnlock()
+
+	// 一併移除此階段的萬用字元訂閱。
+	for _, ch := range e.patternChannels() {
+		e.unsubscribePattern(sess, ch.Event.Name, ch.Name)
+	}
+
+	if removed && e.onDisconnect != nil {
+		e.onDisconnect(sess)
+	}
 }
 
-// subscribe 會替傳入的 Session 訂閱指定的事件與頻道。
-func (e *Engine) subscribe(sess *Session, evtName string, chName string) {
+// subscribe 會替傳入的 Session 訂閱指定的事件與頻道，並在頻道中保存該階段的在線資料。
+// 當 `implicit` 為 `true` 時表示此訂閱來自客戶端，會遵守事件選項中不允許客戶端建立頻道的限制。
+func (e *Engine) subscribe(sess *Session, evtName string, chName string, presence interface{}, implicit bool) error {
+	// 以萬用字元樣式訂閱的事件與頻道會另外保存至樣式索引中。
+	if IsPattern(evtName) || IsPattern(chName) {
+		e.subscribePattern(sess, evtName, chName, presence)
+		return nil
+	}
+	e.lock.Lock()
 	// 如果欲訂閱的事件不存在，就建立一個。
 	evt, ok := e.Events[evtName]
 	if !ok {
-		e.Events[evtName] = &Event{
+		evt = &Event{
 			Name:     evtName,
 			Channels: make(map[string]*Channel),
+			Option:   &EventOption{},
 			engine:   e,
 		}
+		e.Events[evtName] = evt
 	}
-	// 如果欲訂閱的頻道不存在，就建立一個。
+	// 如果欲訂閱的頻道不存在，就在事件選項允許的情況下建立一個。
 	ch, ok := evt.Channels[chName]
 	if !ok {
-		evt.Channels[chName] = &Channel{
-			Name:  chName,
-			Event: evt,
+		if implicit && evt.Option.StrictChannels {
+			e.lock.Unlock()
+			return ErrChannelNotFound
+		}
+		if evt.Option.MaxChannels > 0 && len(evt.Channels) >= evt.Option.MaxChannels {
+			e.lock.Unlock()
+			return ErrTooManyChannels
 		}
+		ch = evt.channel(chName)
+	}
+	e.lock.Unlock()
+
+	// 如果欲訂閱的階段不在其頻道內，就將該階段存至該頻道作為訂閱者，並告知其他訂閱者。
+	added, err := ch.add(sess, presence, evt.Option.MaxSubscribers)
+	if err != nil {
+		return err
 	}
-	// 如果欲訂閱的階段不在其頻道內，就將該階段存至該頻道作為訂閱者。
-	var has bool
-	for _, v := range ch.Sessions {
-		if v == sess {
-			has = true
+	if added {
+		ch.announce("MegoJoin", sess, presence)
+		// 頻道有共享狀態時，新的訂閱者會先收到完整的狀態快照。
+		if ch.Version() > 0 {
+			ch.snapshot(sess)
 		}
 	}
-	if !has {
-		ch.Sessions = append(ch.Sessions, sess)
+	// 開啟離線信箱的事件會記住訂閱的使用者，使用者離線後頻道中的事件就會被放入其信箱。
+	if evt.Option.Mailbox {
+		if user := sess.User(); user != "" {
+			ch.join(user)
+		}
 	}
+	return nil
 }
 
 // unsubscribe 會替傳入的 Session 取消訂閱指定的事件與頻道。
 func (e *Engine) unsubscribe(sess *Session, evtName string, chName string) {
-	if ch, ok := e.Events[evtName].Channels[chName]; ok {
-		ch.Kick(sess.ID)
+	if IsPattern(evtName) || IsPattern(chName) {
+		e.unsubscribePattern(sess, evtName, chName)
+		return
+	}
+	user := sess.User()
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	evt, ok := e.Events[evtName]
+	if !ok {
+		return
+	}
+	if ch, ok := evt.Channels[chName]; ok {
+		if presence, ok := ch.remove(sess); ok {
+			ch.announce("MegoLeave", sess, presence)
+		}
+		if user != "" {
+			ch.leave(user)
+		}
 	}
 }
 
@@ -271,15 +502,9 @@ func (e *Engine) messageHandler(s *melody.Session, msg []byte) {
 			return
 		}
 
-		// 在底層階段存放此階段的編號。
-		s.Set("MegoID", id)
-
-		// 將 Mego 階段放入引擎中保存。
-		e.Sessions[id] = &Session{
-			ID:        id,
-			engine:    e,
-			websocket: s,
-		}
+		// 建立或接回此客戶端的 Mego 階段。
+		e.initialize(s, id, keys)
+		return
 	}
 
 	// 重新取得一次此客戶端的獨立 UUID 編號。
@@ -289,7 +514,9 @@ func (e *Engine) messageHandler(s *melody.Session, msg []byte) {
 	}
 
 	// 透過獨有編號在引擎中找出相對應的階段資料。
+	e.lock.RLock()
 	sess, ok := e.Sessions[id.(string)]
+	e.lock.RUnlock()
 	if !ok {
 		return
 	}
@@ -304,6 +531,7 @@ func (e *Engine) messageHandler(s *melody.Session, msg []byte) {
 			ID:      req.ID,
 			Request: s.Request,
 			data:    req.Params,
+			engine:  e,
 		}
 		// 取得事件訂閱資料，此為陣列。索引 0 為事件名稱、索引 1 為頻道名稱。
 		evt := ctx.Param(0).GetString()
@@ -312,6 +540,11 @@ func (e *Engine) messageHandler(s *melody.Session, msg []byte) {
 		// 執行此客戶端的取消訂閱方法。
 		e.unsubscribe(sess, evt, ch)
 
+		// 有請求編號的話就回應客戶端已取消訂閱。
+		if ctx.ID != 0 {
+			ctx.Respond(nil)
+		}
+
 	// 呼叫 Mego 訂閱方法。
 	case "MEGOSUBSCRIBE":
 		// 建立一個上下文建構體。
@@ -320,19 +553,109 @@ func (e *Engine) messageHandler(s *melody.Session, msg []byte) {
 			ID:      req.ID,
 			Request: s.Request,
 			data:    req.Params,
+			engine:  e,
 		}
-		// 取得事件訂閱資料，此為陣列。索引 0 為事件名稱、索引 1 為頻道名稱。
+		// 處理客戶端的訂閱請求。
+		e.subscribeRequest(ctx)
+
+	// 呼叫 Mego 補收方法，用於客戶端發現事件序號不連續時重新取得遺漏的事件。
+	case "MEGOREPLAY":
+		// 建立一個上下文建構體。
+		ctx := &Context{
+			Session: sess,
+			ID:      req.ID,
+			Request: s.Request,
+			data:    req.Params,
+			engine:  e,
+		}
+		// 索引 0 為事件名稱、索引 1 為頻道名稱、索引 2 為起始序號，索引 3 則是可選的結束序號（不包含）。
 		evt := ctx.Param(0).GetString()
 		ch := ctx.Param(1).GetString()
 
-		// 呼叫訂閱處理函式，如果回傳的是 `true` 才繼續。
-		if e.subscribeHandler != nil {
-			if ok := e.subscribeHandler(evt, ch, ctx); !ok {
-				return
-			}
+		// 僅有已經訂閱該頻道的階段才能補收事件。
+		if !e.subscribed(sess, evt, ch) {
+			return
+		}
+		e.replay(sess, evt, ch, ctx.Param(2).GetInt(), ctx.Param(3).GetInt())
+
+	// 呼叫 Mego 狀態方法，用於客戶端發現共享狀態的版本不連續時重新取得完整的狀態快照。
+	case "MEGOSTATE":
+		// 建立一個上下文建構體。
+		ctx := &Context{
+			Session: sess,
+			ID:      req.ID,
+			Request: s.Request,
+			data:    req.Params,
+			engine:  e,
+		}
+		// 索引 0 為事件名稱、索引 1 為頻道名稱。
+		evt := ctx.Param(0).GetString()
+		ch := ctx.Param(1).GetString()
+
+		// 僅有已經訂閱該頻道的階段才能取得狀態。
+		if !e.subscribed(sess, evt, ch) {
+			return
+		}
+		if c, err := e.channel(evt, ch); err == nil {
+			c.snapshot(sess)
 		}
-		// 執行此客戶端的訂閱方法。
-		e.subscribe(sess, evt, ch)
+
+	// 呼叫 Mego 上傳查詢方法，讓客戶端在斷線重連後得知應該從哪個區塊繼續上傳。
+	case "MEGOUPLOAD":
+		// 建立一個上下文建構體。
+		ctx := &Context{
+			Session: sess,
+			ID:      req.ID,
+			Request: s.Request,
+			data:    req.Params,
+			engine:  e,
+		}
+		// 索引 0 為上傳編號。
+		next, received, done := e.uploadNext(sess, ctx.Param(0).GetString())
+		ctx.Respond(H{
+			"Next":     next,
+			"Received": received,
+			"Done":     done,
+		})
+
+	// 呼叫 Mego 下載確認方法，客戶端以此回報已經接收的位元組位置，讓伺服端繼續傳送下一批區塊。
+	case "MEGODOWNLOAD":
+		// 建立一個上下文建構體。
+		ctx := &Context{
+			Session: sess,
+			ID:      req.ID,
+			Request: s.Request,
+			data:    req.Params,
+			engine:  e,
+		}
+		// 索引 0 為下載編號、索引 1 為已經接收的位元組位置。
+		e.acknowledgeDownload(sess, ctx.Param(0).GetString(), int64(ctx.Param(1).GetInt()))
+
+	// 呼叫 Mego 發布方法，讓客戶端能夠向頻道廣播事件。
+	case "MEGOPUBLISH":
+		// 建立一個上下文建構體。
+		ctx := &Context{
+			Session: sess,
+			ID:      req.ID,
+			Request: s.Request,
+			data:    req.Params,
+			engine:  e,
+		}
+		// 處理客戶端的發布請求。
+		e.publishRequest(ctx)
+
+	// 呼叫 Mego 確認方法，客戶端以此回報已經收到並處理完需要確認的事件。
+	case "MEGOACK":
+		// 建立一個上下文建構體。
+		ctx := &Context{
+			Session: sess,
+			ID:      req.ID,
+			Request: s.Request,
+			data:    req.Params,
+			engine:  e,
+		}
+		// 索引 0 為投遞編號。
+		e.acknowledge(sess, ctx.Param(0).GetString())
 
 	// 呼叫伺服端現有的方法。
 	default:
@@ -348,11 +671,21 @@ func (e *Engine) messageHandler(s *melody.Session, msg []byte) {
 			ID:       req.ID,
 			Request:  s.Request,
 			data:     req.Params,
+			rng:      req.Range,
 			files:    make(map[string][]*File),
 			handlers: e.handlers,
+			engine:   e,
 		}
 		// 將該方法的處理函式推入上下文建構體中供依序執行。
 		ctx.handlers = append(ctx.handlers, method.Handlers...)
+		// 請求結束後移除沒有被處理函式保留的上傳檔案。
+		defer e.cleanFiles(ctx)
+
+		// 超過方法所允許大小的請求不會被處理。
+		if max := e.maxSize(method); max > 0 && len(msg) > max {
+			ctx.RespondWithError(StatusFileTooLarge, nil, ErrRequestTooLarge)
+			return
+		}
 
 		// 解析上傳的檔案。
 		if done := e.fileHandler(ctx, req.Files); !done {
@@ -360,6 +693,10 @@ func (e *Engine) messageHandler(s *melody.Session, msg []byte) {
 			// 告訴客戶端上傳下一個區塊。
 			return
 		}
+		// 在呼叫方法處理函式前以檔案處理函式處理所有的檔案，被拒絕時就不繼續執行。
+		if done := e.processFiles(ctx); !done {
+			return
+		}
 
 		// 如果處理函式數量大於零的話就可以開始執行了。
 		if len(ctx.handlers) > 0 {
@@ -368,54 +705,169 @@ func (e *Engine) messageHandler(s *melody.Session, msg []byte) {
 	}
 }
 
-// chunkHandler 是預設的區塊處理函式，這會接收區塊並組成一個檔案。
-func chunkHandler(c *Context, raw *RawFile, dest *File) ChunkStatus {
-	// 在系統中建立並開啟一個新的暫存檔案。
-	t, err := os.OpenFile(fmt.Sprintf("%s/MEGO_CHUNK_%s_%d", os.TempDir(), c.Session.ID, raw.ID), os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
-	if err != nil {
+// subscribeRequest 會檢查並處理來自客戶端的訂閱請求，並將訂閱結果或被拒的原因回應給客戶端。
+func (e *Engine) subscribeRequest(ctx *Context) {
+	// 取得事件訂閱資料，此為陣列。索引 0 為事件名稱、索引 1 為頻道名稱。
+	evtName := ctx.Param(0).GetString()
+	chName := ctx.Param(1).GetString()
 
+	// refuse 會告知客戶端訂閱被拒，除非處理函式已經自行回應了被拒的原因。
+	refuse := func(code int, err error) {
+		if ctx.ID != 0 && !ctx.responded {
+			ctx.RespondWithError(code, nil, err)
+		}
 	}
-	// 將使用者上傳的位元組內容寫入暫存檔案中，並取得該位元組長度做為檔案大小。
-	size, err := t.Write(raw.Binary)
-	if err != nil {
 
+	// 找出此次訂閱所涵蓋的已宣告事件，以萬用字元樣式訂閱時會涵蓋所有符合樣式的事件。
+	pattern := IsPattern(evtName) || IsPattern(chName)
+	var options []*EventOption
+	e.lock.RLock()
+	_, declared := e.Events[evtName]
+	for name, evt := range e.Events {
+		if name == evtName || (IsPattern(evtName) && Match(evtName, name)) {
+			options = append(options, evt.Option)
+		}
+	}
+	e.lock.RUnlock()
+
+	// 嚴格模式下僅能訂閱已經宣告的事件。
+	if e.Option.StrictEvents && (!declared || IsPattern(evtName)) {
+		refuse(StatusNotFound, ErrEventNotFound)
+		return
+	}
+	for _, option := range options {
+		// 檢查階段是否帶有事件所要求的鍵值組。
+		for _, v := range option.RequireKeys {
+			if val, ok := ctx.Session.Get(v); !ok || val == nil {
+				refuse(StatusNotAuthorized, ErrNotAuthorized)
+				return
+			}
+		}
+		// 萬用字元訂閱無法遵守限制頻道或訂閱人數的事件，因此會被拒絕。
+		if pattern && (option.StrictChannels || option.MaxSubscribers > 0) {
+			refuse(StatusNoPermission, ErrSubscriptionRefused)
+			return
+		}
+	}
+	// 呼叫訂閱處理函式，全域與所有涵蓋事件的處理函式都回傳 `true` 才繼續。
+	if e.subscribeHandler != nil && !e.subscribeHandler(evtName, chName, ctx) {
+		refuse(StatusNoPermission, ErrSubscriptionRefused)
+		return
+	}
+	for _, option := range options {
+		if option.SubscribeHandler != nil && !option.SubscribeHandler(evtName, chName, ctx) {
+			refuse(StatusNoPermission, ErrSubscriptionRefused)
+			return
+		}
+	}
+	// 執行此客戶端的訂閱方法。
+	if err := e.subscribe(ctx.Session, evtName, chName, nil, true); err != nil {
+		switch err {
+		case ErrChannelFull, ErrTooManyChannels:
+			refuse(StatusFull, err)
+		case ErrChannelNotFound:
+			refuse(StatusNotFound, err)
+		default:
+			refuse(StatusError, err)
+		}
+		return
 	}
-	// 關閉檔案。
-	if err := t.Close(); err != nil {
 
+	// 有請求編號的話就回應客戶端已訂閱成功。
+	if ctx.ID != 0 {
+		ctx.Respond(nil)
 	}
+	// 索引 2 是可選的起始序號，客戶端會補收序號大於此數的歷史事件。
+	if ctx.hasParam(2) {
+		e.replay(ctx.Session, evtName, chName, ctx.Param(2).GetInt(), 0)
+	}
+}
 
+// chunkHandler 是預設的區塊處理函式，這會將接收到的區塊依照其位元組位置寫入至檔案儲存裝置中並組成一個檔案。
+// 區塊能以任意順序抵達，每個上傳都會記錄已經接收的區塊，讓客戶端在斷線重連後只需要補傳遺漏的區塊。
+func chunkHandler(c *Context, raw *RawFile, dest *File) ChunkStatus {
+	e := c.engine
+	store := e.fileStore()
+
+	if len(raw.Parts) != 2 {
+		return ChunkAbort
+	}
 	// 取得總區塊數。
 	total := raw.Parts[0]
 	// 取得本區塊編號。
 	current := raw.Parts[1]
+	if current < 1 || current > total || raw.Offset < 0 {
+		return ChunkAbort
+	}
 
-	// 如果這是最後一個區塊就回傳處理完成狀態碼。
-	if current == total {
-		// 從檔案名稱中取得名稱與副檔名。
-		ext := filepath.Ext(raw.Name)
-		name := strings.TrimSuffix(raw.Name, ext)
+	// 如果這是此上傳的第一個區塊，就在儲存裝置中建立一個新的檔案。
+	u, err := e.upload(c.Session, raw)
+	if err != nil {
+		return ChunkAbort
+	}
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	// 同個上傳中的區塊必須屬於相同大小與區塊數量的檔案。
+	if raw.Size != u.length || total != u.count {
+		return ChunkAbort
+	}
 
-		// 如果副檔名長度過短就是出問題了。
-		if len(ext) < 2 {
+	// 將使用者上傳的位元組內容寫入至檔案中的指定位置。重複抵達的區塊（例如斷線前已經寫入但客戶端沒有收到回應）會寫入相同的內容。
+	if err := store.WriteAt(u.key, raw.Binary, raw.Offset); err != nil {
+		e.discardUpload(u)
+		return ChunkAbort
+	}
+	u.parts[current] = int64(len(raw.Binary))
 
+	// 如果還有區塊沒有到齊就請求下一個區塊。
+	if len(u.parts) < total {
+		return ChunkNext
+	}
+	// 整個檔案的摘要不符時就捨棄已經接收的內容，客戶端會再次詢問並從第一個區塊重新上傳。
+	hash, contentType, err := e.digest(u.key)
+	if err != nil {
+		e.discardUpload(u)
+		return ChunkAbort
+	}
+	if raw.Digest != "" && !strings.EqualFold(raw.Digest, hash) {
+		if err := e.restartUpload(u, raw.Name); err != nil {
+			e.discardUpload(u)
+			return ChunkAbort
 		}
-
-		// 將正確的檔案資料配置到檔案建構體中。
-		dest.Name = name
-		dest.Extension = ext[1:]
-		dest.Path = t.Name()
-		dest.Size = size
-
-		//
-		return ChunkDone
+		return ChunkRetry
 	}
-	return ChunkNext
+	size, err := store.Finalize(u.key)
+	if err != nil {
+		e.discardUpload(u)
+		return ChunkAbort
+	}
+	// 將正確的檔案資料配置到檔案建構體中。
+	*dest = *newFile(store, u.key, raw.Name, size)
+	dest.Hash = hash
+	dest.ContentType = contentType
+	return ChunkDone
 }
 
-// fileHandler 會處理並解析接收到的檔案。如果接收到了區塊內容，則會呼叫額外的區塊處理函式。
-// 在這種情況本函式會回傳 `false` 來終止請求的繼續，直到所有區塊都處理完為止。
+// fileHandler 會處理並解析接收到的檔案，並在所有檔案都備妥後回傳 `true` 讓請求繼續執行。
+// 帶有區塊的請求只用來傳送區塊，組合完畢的檔案會被保存起來，直到客戶端在最後的請求中以上傳編號認領，
+// 因此一個請求能同時夾帶多個區塊檔案與一般檔案，而方法處理函式只會在所有檔案都完成後被呼叫一次。
 func (e *Engine) fileHandler(c *Context, fields map[string][]*RawFile) bool {
+	// 如果請求中有區塊內容，就交由區塊處理函式處理並終止本次請求。
+	for _, files := range fields {
+		for _, f := range files {
+			if len(f.Parts) > 0 {
+				e.chunkRequest(c, fields)
+				return false
+			}
+		}
+	}
+
+	// 在儲存任何檔案前先檢查數量、副檔名與內容種類是否符合方法的限制。
+	if err := e.checkFiles(c, fields); err != nil {
+		c.RespondWithError(fileStatus(err), nil, err)
+		return false
+	}
+
 	// 遍歷每個檔案欄位。
 	for field, files := range fields {
 		// 如果這個檔案欄位不存在於本地的上下文建構體中，
@@ -426,90 +878,202 @@ func (e *Engine) fileHandler(c *Context, fields map[string][]*RawFile) bool {
 
 		// 遍歷這個檔案欄位中的所有檔案。
 		for _, f := range files {
-			// 如果這個檔案內容不是最後結果，即表示這是個區塊內容。
-			if len(f.Parts) > 0 {
-				// 初始化一個目標檔案，在區塊組合完畢後就使用這個檔案建構體。
-				var dest *File
-				var status ChunkStatus
-
-				// 呼叫區塊處理函式。
-				switch {
-				// 如果此方法有自訂的區塊處理函式則優先呼叫。
-				case c.Method.ChunkHandler != nil:
-					status = c.Method.ChunkHandler(c, f, dest)
-				// 沒有則就呼叫全域區塊處理函式。
-				case e.chunkHandler != nil:
-					status = e.chunkHandler(c, f, dest)
-				}
-
-				// 依照區塊處理的狀態碼決定下一步。
-				switch status {
-				// ChunkNext 表示本次處理成功，請求下一個檔案區塊。
-				case ChunkNext:
-					c.Session.write(Response{
-						Event: "MegoChunkNext",
-						ID:    c.ID,
-					})
-					// 結束本次請求，避免區塊還沒處理完畢就繼續呼叫了接下來的方法函式。
+			// 帶有上傳編號的檔案是已經透過區塊組合完畢的檔案。
+			if f.Upload != "" {
+				file, err := e.claimUpload(c.Session, uploadID(c.Session, f))
+				if err != nil {
+					c.RespondWithError(StatusNotFound, nil, err)
 					return false
-				// ChunkAbort 表示不打算處理本檔案了，結束此檔案的處理手續並停止上傳。
-				case ChunkAbort:
-					c.Session.write(Response{
-						Event: "MegoChunkAbort",
-						ID:    c.ID,
-					})
-					// 結束本次請求，避免區塊還沒處理完畢就繼續呼叫了接下來的方法函式。
+				}
+				c.files[field] = append(c.files[field], file)
+				// 區塊上傳的檔案要到組合完畢後才能得知內容種類，不符時檔案會在請求結束後被移除。
+				if o := c.Method.fileOption(field); o != nil && !o.allowType(file.ContentType) {
+					c.RespondWithError(StatusInvalid, nil, ErrFileNotAllowed)
 					return false
-				// ChunkDone 表示所有區塊皆處理完畢，結束檔案處理。
-				case ChunkDone:
-					// 將這個檔案整理後推入至上下文建構體中的檔案欄位。
-					c.files[field] = append(c.files[field], dest)
-					// 繼續本次請求，並呼叫接下來的方法函式。
-					return true
 				}
+				continue
 			}
-
-			// 在系統中建立並開啟一個新的暫存檔案。
-			t, err := ioutil.TempFile("", "")
+			// 檔案內容與客戶端傳來的雜湊或摘要不符時要求客戶端重新上傳。
+			if (f.Hash != "" && !verifyChecksum(f.Hash, f.Binary)) || (f.Digest != "" && !verifyChecksum(f.Digest, f.Binary)) {
+				c.RespondWithError(StatusFileRetry, nil, ErrChecksumMismatch)
+				return false
+			}
+			// 將使用者上傳的位元組內容存放至檔案儲存裝置中。
+			file, err := e.storeFile(f.Name, f.Binary)
 			if err != nil {
-
+				c.RespondWithError(StatusError, nil, err)
+				return false
 			}
 
-			// 將使用者上傳的位元組內容寫入暫存檔案中，並取得該位元組長度做為檔案大小。
-			size, err := t.Write(f.Binary)
-			if err != nil {
+			// 將這個檔案整理後推入至上下文建構體中的檔案欄位。
+			c.files[field] = append(c.files[field], file)
+		}
+	}
 
+	return true
+}
+
+// checkFiles 會依照方法的檔案限制檢查請求中的檔案，不符合時回傳 `ErrFileTooLarge`、`ErrTooManyFiles` 或 `ErrFileNotAllowed`。
+// 一般檔案會直接以內容判斷種類，而區塊上傳的檔案則要等到組合完畢後才能判斷。
+func (e *Engine) checkFiles(c *Context, fields map[string][]*RawFile) error {
+	for field, files := range fields {
+		for _, f := range files {
+			if int64(len(f.Binary)) > e.maxFileSize(c.Method) {
+				return ErrFileTooLarge
+			}
+		}
+		o := c.Method.fileOption(field)
+		if o == nil {
+			continue
+		}
+		if o.MaxFiles > 0 && len(files) > o.MaxFiles {
+			return ErrTooManyFiles
+		}
+		for _, f := range files {
+			if !o.allowExtension(f.Name) {
+				return ErrFileNotAllowed
+			}
+			if f.Upload == "" && !o.allowType(sniff(f.Binary)) {
+				return ErrFileNotAllowed
 			}
+		}
+	}
+	return nil
+}
+
+// checkChunk 會檢查區塊的編號與位置是否正確、大小是否符合方法的限制，以及其檔案是否符合方法的檔案限制。
+// 第一個位元組位置的區塊能直接判斷內容種類，讓不被允許的檔案在上傳前就被拒絕。
+func (e *Engine) checkChunk(c *Context, field string, raw *RawFile) error {
+	if len(raw.Parts) != 2 || raw.Parts[0] < 1 || raw.Parts[1] < 1 || raw.Parts[1] > raw.Parts[0] || raw.Offset < 0 {
+		return ErrInvalidChunk
+	}
+	if raw.Size > e.maxFileSize(c.Method) {
+		return ErrFileTooLarge
+	}
+	if max := e.maxChunkSize(c.Method); max > 0 && len(raw.Binary) > max {
+		return ErrFileTooLarge
+	}
+	if _, ok := chunkLayout(raw); !ok {
+		return ErrInvalidChunk
+	}
+	o := c.Method.fileOption(field)
+	if o == nil {
+		return nil
+	}
+	if !o.allowExtension(raw.Name) || (raw.Parts[1] == 1 && raw.Offset == 0 && !o.allowType(sniff(raw.Binary))) {
+		return ErrFileNotAllowed
+	}
+	return nil
+}
 
-			// 從檔案名稱中取得名稱與副檔名。
-			ext := filepath.Ext(f.Name)
-			name := strings.TrimSuffix(f.Name, ext)
-			fmt.Printf("%+v", f)
-			// 如果副檔名長度過短就是出問題了。
-			if len(ext) < 2 {
-				panic("Fuck")
+// chunkRequest 會將請求中的每個區塊交由區塊處理函式，並依照處理的結果回應客戶端。
+// 同個請求中的一般檔案會被忽略，因為帶有區塊的請求並不會呼叫方法處理函式。
+func (e *Engine) chunkRequest(c *Context, fields map[string][]*RawFile) {
+	// 在處理任何區塊前先檢查所有區塊，格式不正確或不被允許的檔案會直接被拒絕。
+	for field, files := range fields {
+		for _, f := range files {
+			if len(f.Parts) == 0 {
+				continue
+			}
+			if err := e.checkChunk(c, field, f); err != nil {
+				c.RespondWithError(fileStatus(err), nil, err)
+				return
 			}
+		}
+	}
 
-			// 將這個檔案整理後推入至上下文建構體中的檔案欄位。
-			c.files[field] = append(c.files[field], &File{
-				Name:      name,
-				Extension: ext[1:],
-				Path:      t.Name(),
-				Size:      size,
-			})
+	var next, retry, abort *RawFile
+	for _, files := range fields {
+		for _, f := range files {
+			if len(f.Parts) == 0 {
+				continue
+			}
+			switch e.receiveChunk(c, f) {
+			case ChunkNext:
+				next = f
+			case ChunkRetry:
+				retry = f
+			case ChunkAbort:
+				abort = f
+			}
 		}
 	}
 
-	return true
+	// 依照區塊處理的狀態決定如何回應，有多個區塊時以最嚴重的狀態為主。
+	switch {
+	// ChunkAbort 表示不打算處理本檔案了，結束此檔案的處理手續並停止上傳。
+	case abort != nil:
+		c.Session.write(Response{
+			Event: "MegoChunkAbort",
+			ID:    c.ID,
+		})
+	// ChunkRetry 表示區塊內容有誤，要求客戶端重新傳送。
+	case retry != nil:
+		c.RespondWithError(StatusFileRetry, nil, ErrChecksumMismatch)
+	// ChunkNext 表示本次處理成功，請求下一個檔案區塊。
+	case next != nil:
+		c.Session.write(Response{
+			Event: "MegoChunkNext",
+			ID:    c.ID,
+			Result: H{
+				"Next": e.nextChunk(c.Session, next),
+			},
+		})
+	// ChunkDone 表示所有區塊皆處理完畢，檔案會被保存直到客戶端認領。
+	default:
+		c.Session.write(Response{
+			Event: "MegoChunkDone",
+			ID:    c.ID,
+		})
+	}
+}
+
+// receiveChunk 會檢查區塊內容並呼叫區塊處理函式，組合完畢的檔案會被保存起來等待客戶端認領。
+func (e *Engine) receiveChunk(c *Context, raw *RawFile) ChunkStatus {
+	// 區塊內容與客戶端傳來的雜湊不符時要求客戶端重新傳送此區塊。
+	if raw.Hash != "" && !verifyChecksum(raw.Hash, raw.Binary) {
+		return ChunkRetry
+	}
+	// 已經組合完畢的檔案又收到重複的區塊（例如客戶端沒有收到完成的回應）時不需要再次處理。
+	if e.finished(c.Session, uploadID(c.Session, raw)) {
+		return ChunkDone
+	}
+	// 初始化一個目標檔案，在區塊組合完畢後就使用這個檔案建構體。
+	dest := &File{}
+	var status ChunkStatus
+
+	// 呼叫區塊處理函式。
+	switch {
+	// 如果此方法有自訂的區塊處理函式則優先呼叫。
+	case c.Method.ChunkHandler != nil:
+		status = c.Method.ChunkHandler(c, raw, dest)
+	// 沒有則就呼叫全域區塊處理函式。
+	case e.chunkHandler != nil:
+		status = e.chunkHandler(c, raw, dest)
+	}
+	if status == ChunkDone {
+		e.finishUpload(c.Session, raw, dest)
+	}
+	if status == ChunkNext || status == ChunkDone {
+		e.uploadProgress(c, raw)
+	}
+	return status
 }
 
 // HandleSubscribe 會更改預設的事件訂閱檢查函式，開發者可傳入一個回呼函式並接收客戶端欲訂閱的事件與頻道和相關資料。
 // 回傳一個 `false` 即表示客戶端的資格不符，將不納入訂閱清單中。該客戶端將無法接收到指定的事件。
+// 被拒的客戶端預設會收到 `StatusNoPermission` 錯誤，若要告知其他原因，請在回傳 `false` 前以 `RespondWithError` 自行回應。
 func (e *Engine) HandleSubscribe(handler SubscribeHandler) *Engine {
 	e.subscribeHandler = handler
 	return e
 }
 
+// HandleDisconnect 會設置階段斷線時所呼叫的處理函式。如果有設置恢復期限，則會在期限過後階段被正式移除時才呼叫。
+func (e *Engine) HandleDisconnect(handler func(*Session)) *Engine {
+	e.onDisconnect = handler
+	return e
+}
+
 // HandleChunk 會更改預設的區塊處理函式，開發者可以傳入一個回呼函式並接收區塊內容。
 // 回傳 `ChunkStatus` 來告訴 Mego 區塊的處理狀態如何。
 func (e *Engine) HandleChunk(handler ChunkHandler) *Engine {
@@ -525,11 +1089,32 @@ func (e *Engine) Use(handlers ...HandlerFunc) *Engine {
 
 // Len 會回傳目前有多少個連線數。
 func (e *Engine) Len() int {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
 	return len(e.Sessions)
 }
 
-// Close 會結束此引擎的服務。
+// Close 會結束此引擎的服務，所有尚未執行的排程工作都會被取消，
+// 所有階段都會以 `CloseShutdown` 作為原因斷線。
 func (e *Engine) Close() error {
+	e.cancelJobs()
+	e.stopJanitor()
+	if e.server == nil {
+		return nil
+	}
+	// 先以 `CloseShutdown` 告知所有階段伺服端正在關閉，讓客戶端能夠分辨這不是意外的斷線。
+	e.lock.RLock()
+	sessions := make([]*Session, 0, len(e.Sessions))
+	for _, sess := range e.Sessions {
+		sessions = append(sessions, sess)
+	}
+	e.lock.RUnlock()
+	for _, sess := range sessions {
+		sess.Disconnect(DisconnectReason{
+			Code:    CloseShutdown,
+			Message: "server shutdown",
+		})
+	}
 	e.server.websocket.Close()
 	return nil
 }
@@ -540,11 +1125,27 @@ func (e *Engine) NoMethod(handler ...HandlerFunc) *Engine {
 	return e
 }
 
-// Event 會建立一個新的事件，如此一來客戶端方能監聽。
-func (e *Engine) Event(name string) {
-	e.Events[name] = &Event{
-		Name: name,
+// Event 會宣告一個新的事件，如此一來客戶端方能監聽。可以額外傳入事件選項來限制此事件的訂閱方式。
+// 如果事件已經存在（例如已被客戶端訂閱過），則會沿用其頻道並更新事件選項。
+func (e *Engine) Event(name string, option ...EventOption) *Event {
+	o := &EventOption{}
+	if len(option) > 0 {
+		o = &option[0]
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if evt, ok := e.Events[name]; ok {
+		evt.Option = o
+		return evt
+	}
+	evt := &Event{
+		Name:     name,
+		Channels: make(map[string]*Channel),
+		Option:   o,
+		engine:   e,
 	}
+	e.Events[name] = evt
+	return evt
 }
 
 // Register 會註冊一個指定的方法，並且允許客戶端呼叫此方法觸發指定韓式。
@@ -559,36 +1160,216 @@ func (e *Engine) Register(method string, handler ...HandlerFunc) *Method {
 
 // Emit 會帶有指定資料並廣播指定事件與頻道，當頻道為空字串時則廣播到所有頻道。
 func (e *Engine) Emit(event string, channel string, result interface{}) error {
+	return e.emit(event, channel, result, nil, nil, e.eventOption(event).Acknowledge)
+}
+
+// emit 會廣播指定事件至頻道與符合的樣式頻道，並略過 `exclude` 所指定的階段。
+// 有傳入過濾函式時僅會傳送給過濾函式回傳 `true` 的訂閱者，且事件不會保存至歷史紀錄，避免被其他訂閱者補收。
+// 當 `ack` 為 `true` 時會要求每個訂閱者確認收到。
+func (e *Engine) emit(event string, channel string, result interface{}, exclude *Session, filter func(*Session) bool, ack bool) error {
+	ch, err := e.channel(event, channel)
+	// 透過樣式索引找出以萬用字元訂閱此事件與頻道的樣式頻道。
+	patterns := e.matchPatterns(event, channel)
+	if err != nil && len(patterns) == 0 {
+		return err
+	}
+	// 替這則事件配發頻道序號並保存至歷史紀錄，讓之後訂閱的客戶端能夠補收。
+	// 配發序號到寫入完畢之間都持有頻道的傳送鎖，避免同時廣播的事件以不同於序號的順序送達。
+	var seq int
+	if ch != nil {
+		ch.sendLock.Lock()
+		defer ch.sendLock.Unlock()
+		seq = ch.next()
+		if filter == nil {
+			var id string
+			if exclude != nil {
+				id = exclude.ID
+			}
+			e.record(event, channel, seq, result, id)
+		}
+	}
+
+	recipients := e.recipients(ch, patterns)
+	// 開啟離線信箱的事件會放入頻道中離線成員的使用者信箱。
+	if ch != nil && filter == nil {
+		if err := e.postMembers(ch, recipients, event, channel, result); err != nil {
+			return err
+		}
+	}
+
+	// 事件只需要編碼一次，就能寫入給所有的訂閱者。
+	var sessions []*Session
+	for _, v := range recipients {
+		if v != exclude && (filter == nil || filter(v)) {
+			sessions = append(sessions, v)
+		}
+	}
+	return e.send(sessions, Response{
+		Event:    event,
+		Channel:  channel,
+		Sequence: seq,
+		Result:   result,
+	}, ack)
+}
+
+// recipients 會合併頻道與樣式頻道的訂閱者，同時符合多個訂閱的階段只會出現一次。
+func (e *Engine) recipients(ch *Channel, patterns []*Channel) []*Session {
+	var list []*Session
+	if ch != nil {
+		list = ch.sessions()
+	}
+	if len(patterns) == 0 {
+		return list
+	}
+	seen := make(map[*Session]bool, len(list))
+	for _, v := range list {
+		seen[v] = true
+	}
+	for _, p := range patterns {
+		for _, v := range p.sessions() {
+			if !seen[v] {
+				seen[v] = true
+				list = append(list, v)
+			}
+		}
+	}
+	return list
+}
+
+// subscribed 會回傳指定階段是否有直接或透過萬用字元樣式訂閱指定的事件與頻道。
+func (e *Engine) subscribed(sess *Session, event string, channel string) bool {
+	if ch, err := e.channel(event, channel); err == nil && ch.has(sess) {
+		return true
+	}
+	for _, v := range e.matchPatterns(event, channel) {
+		if v.has(sess) {
+			return true
+		}
+	}
+	return false
+}
+
+// channel 會取得指定事件中的指定頻道。
+func (e *Engine) channel(event string, channel string) (*Channel, error) {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
 	evt, ok := e.Events[event]
 	if !ok {
-		return ErrEventNotFound
+		return nil, ErrEventNotFound
 	}
 	ch, ok := evt.Channels[channel]
 	if !ok {
-		return ErrChannelNotFound
+		return nil, ErrChannelNotFound
 	}
-	var firstErr error
-	for _, v := range ch.Sessions {
-		v.write(Response{
-			Event:  event,
-			Result: result,
-		})
-		//err := v.websocket.WriteBinary()
-		//if firstErr == nil {
-		//	firstErr = err
-		//}
+	return ch, nil
+}
+
+// Broadcast 會向所有已連線的階段廣播指定事件，此事件不需要事先訂閱，適合用在維護公告等全系統的通知。
+func (e *Engine) Broadcast(event string, result interface{}) error {
+	return e.BroadcastFilter(event, result, func(*Session) bool {
+		return true
+	})
+}
+
+// BroadcastFilter 會以過濾函式來決定要將全系統廣播的事件送給哪些階段。
+// 如果過濾函式回傳 `true` 則表示該客戶端會接收到該事件。
+func (e *Engine) BroadcastFilter(event string, result interface{}, filter func(*Session) bool) error {
+	var sessions []*Session
+	for _, v := range e.sessions() {
+		if filter(v) {
+			sessions = append(sessions, v)
+		}
 	}
+	// 事件只需要編碼一次，就能寫入給所有的階段。
+	return e.send(sessions, Response{
+		Event:  event,
+		Result: result,
+	}, e.eventOption(event).Acknowledge)
+}
 
-	return firstErr
+// sessions 會回傳目前所有階段的切片，讓廣播時不需要持有引擎的鎖。
+func (e *Engine) sessions() []*Session {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	list := make([]*Session, 0, len(e.Sessions))
+	for _, v := range e.Sessions {
+		list = append(list, v)
+	}
+	return list
 }
 
 // EmitMultiple 會將指定事件與資料向指定的客戶端切片進行廣播。
+// 事件一樣會配發頻道序號並依照事件選項要求確認收到，但不會保存至頻道的歷史紀錄。
 func (e *Engine) EmitMultiple(event string, channel string, result interface{}, sessions []*Session) error {
-	return nil
+	targets := make(map[*Session]bool, len(sessions))
+	for _, v := range sessions {
+		targets[v] = true
+	}
+	return e.EmitFilter(event, channel, result, func(s *Session) bool {
+		return targets[s]
+	})
 }
 
 // EmitFilter 會以過濾函式來決定要將帶有指定資料的事件廣播給誰。
 // 如果過濾函式回傳 `true` 則表示該客戶端會接收到該事件。
+// 事件一樣會配發頻道序號並依照事件選項要求確認收到，但不會保存至頻道的歷史紀錄，避免被沒有接收到的訂閱者補收。
 func (e *Engine) EmitFilter(event string, channel string, payload interface{}, filter func(*Session) bool) error {
+	return e.emit(event, channel, payload, nil, filter, e.eventOption(event).Acknowledge)
+}
+
+// EmitFunc 會依照每個訂閱者各自產生資料並廣播指定事件，適合用在需要依接收者隱藏欄位、翻譯文字等情況。
+// 函式回傳 `false` 時則略過該訂閱者；回傳與先前相同的資料時會沿用已編碼的內容而不會重新編碼，
+// 因此若回傳的是同一個映射或指標，請不要在廣播期間修改其內容。
+// 透過此函式廣播的事件和 `Emit` 一樣會配發頻道序號並依序送達，但由於資料因人而異，不會保存至頻道的歷史紀錄。
+// 事件選項要求確認收到時，每個訂閱者都會有各自的投遞編號，此時每份資料都會各自編碼。
+func (e *Engine) EmitFunc(event string, channel string, fn func(*Session) (interface{}, bool)) error {
+	ch, err := e.channel(event, channel)
+	patterns := e.matchPatterns(event, channel)
+	if err != nil && len(patterns) == 0 {
+		return err
+	}
+	// 和 `emit` 相同，配發序號到寫入完畢之間都持有頻道的傳送鎖。
+	var seq int
+	if ch != nil {
+		ch.sendLock.Lock()
+		defer ch.sendLock.Unlock()
+		seq = ch.next()
+	}
+	ack := e.eventOption(event).Acknowledge
+	var cache encodeCache
+	for _, v := range e.recipients(ch, patterns) {
+		result, ok := fn(v)
+		if !ok {
+			continue
+		}
+		resp := Response{
+			Event:    event,
+			Channel:  channel,
+			Sequence: seq,
+			Result:   result,
+		}
+		var msg []byte
+		if ack {
+			resp.Delivery = uuid.NewV4().String()
+			msg, err = msgpack.Marshal(resp)
+		} else {
+			msg, err = cache.encode(result, func() ([]byte, error) {
+				return msgpack.Marshal(resp)
+			})
+		}
+		if err != nil {
+			return err
+		}
+		if ack {
+			e.track(v, Delivery{
+				ID:       resp.Delivery,
+				Event:    event,
+				Channel:  channel,
+				Result:   result,
+				Attempts: 1,
+			}, msg)
+		}
+		v.writeBinary(msg)
+	}
 	return nil
 }
@@ -0,0 +1,89 @@
+package mego
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmihailenco/msgpack"
+)
+
+func TestEmitFilter(t *testing.T) {
+	e, sess, _ := newTestEngine()
+	other := &Session{
+		ID:       "other",
+		engine:   e,
+		detached: true,
+	}
+	e.Sessions[other.ID] = other
+	e.Option.HistoryLength = 10
+	e.Event("Event", EventOption{
+		Acknowledge: true,
+	})
+	assert.NoError(t, e.subscribe(sess, "Event", "Channel", nil, false))
+	assert.NoError(t, e.subscribe(other, "Event", "Channel", nil, false))
+
+	assert.NoError(t, e.Emit("Event", "Channel", 1))
+	assert.NoError(t, e.EmitMultiple("Event", "Channel", 2, []*Session{sess}))
+	assert.NoError(t, e.Emit("Event", "Channel", 3))
+
+	// 過濾後的事件仍會配發序號並要求確認收到。
+	sequences := func(s *Session) (list []int) {
+		for _, msg := range s.queue {
+			var resp Response
+			assert.NoError(t, msgpack.Unmarshal(msg, &resp))
+			list = append(list, resp.Sequence)
+		}
+		return
+	}
+	assert.Equal(t, []int{1, 2, 3}, sequences(sess))
+	assert.Equal(t, []int{1, 3}, sequences(other))
+	assert.Len(t, sess.deliveries, 3)
+	assert.Len(t, other.deliveries, 2)
+
+	// 過濾後的事件不會被其他訂閱者補收。
+	other.queue = nil
+	e.replay(other, "Event", "Channel", 0, 0)
+	assert.Equal(t, []int{1, 3}, sequences(other))
+}
+
+func TestEmitFunc(t *testing.T) {
+	e, sess, _ := newTestEngine()
+	other := &Session{
+		ID:       "other",
+		engine:   e,
+		detached: true,
+	}
+	e.Sessions[other.ID] = other
+	e.Event("Event", EventOption{
+		Acknowledge: true,
+	})
+	assert.NoError(t, e.subscribe(sess, "Event", "Channel", nil, false))
+	assert.NoError(t, e.subscribe(other, "Event", "Channel", nil, false))
+
+	assert.NoError(t, e.Emit("Event", "Channel", 1))
+	assert.NoError(t, e.EmitFunc("Event", "Channel", func(s *Session) (interface{}, bool) {
+		return s.ID, true
+	}))
+	assert.NoError(t, e.Emit("Event", "Channel", 3))
+
+	// 依接收者產生的事件也會配發序號，且每個訂閱者都有各自的投遞編號。
+	responses := func(s *Session) (list []Response) {
+		for _, msg := range s.queue {
+			var resp Response
+			assert.NoError(t, msgpack.Unmarshal(msg, &resp))
+			list = append(list, resp)
+		}
+		return
+	}
+	a, b := responses(sess), responses(other)
+	for i, seq := range []int{1, 2, 3} {
+		assert.Equal(t, seq, a[i].Sequence)
+		assert.Equal(t, seq, b[i].Sequence)
+	}
+	assert.Equal(t, "session", a[1].Result)
+	assert.Equal(t, "other", b[1].Result)
+	assert.NotEmpty(t, a[1].Delivery)
+	assert.NotEqual(t, a[1].Delivery, b[1].Delivery)
+	assert.Contains(t, sess.deliveries, a[1].Delivery)
+	assert.Contains(t, other.deliveries, b[1].Delivery)
+}
@@ -0,0 +1,278 @@
+package mego
+
+import (
+	"path"
+	"strings"
+)
+
+// IsPattern 會回傳傳入的事件或頻道名稱是否為萬用字元樣式。
+// 名稱以 `.` 分段，每段能使用 `*`、`?` 與 `[...]` 等萬用字元，而最後一段為 `>` 時表示比對其餘的所有段落。
+func IsPattern(name string) bool {
+	return strings.ContainsAny(name, "*?[") || name == ">" || strings.HasSuffix(name, ".>")
+}
+
+// Match 會回傳指定的事件或頻道名稱是否符合萬用字元樣式。
+func Match(pattern string, name string) bool {
+	p := strings.Split(pattern, ".")
+	n := strings.Split(name, ".")
+	for i, v := range p {
+		// `>` 僅能作為最後一段，並且至少要比對一個段落。
+		if v == ">" && i == len(p)-1 {
+			return len(n) > i
+		}
+		if i >= len(n) {
+			return false
+		}
+		if ok, _ := path.Match(v, n[i]); !ok {
+			return false
+		}
+	}
+	return len(p) == len(n)
+}
+
+// patternNode 是萬用字元訂閱索引樹中的一個節點，名稱中以 `.` 分隔的每一段都是樹的一層。
+// 廣播時只需要沿著事件名稱的段落走訪，而不必逐一比對所有的萬用字元訂閱。
+type patternNode struct {
+	// literals 是不含萬用字元的子節點。
+	literals map[string]*patternNode
+	// globs 是含有萬用字元的子節點，僅會與同一層的段落比對。
+	globs map[string]*patternNode
+	// tail 是以 `>` 表示比對其餘所有段落的子節點。
+	tail *patternNode
+	// value 是終點節點所保存的資料。
+	value interface{}
+}
+
+// newPatternNode 會建立一個空白的索引節點。
+func newPatternNode() *patternNode {
+	return &patternNode{
+		literals: make(map[string]*patternNode),
+		globs:    make(map[string]*patternNode),
+	}
+}
+
+// child 會回傳指定段落的子節點，當 `create` 為 `true` 時會在子節點不存在時建立一個。
+func (n *patternNode) child(segment string, create bool) *patternNode {
+	switch {
+	case segment == ">":
+		if n.tail == nil && create {
+			n.tail = newPatternNode()
+		}
+		return n.tail
+	case IsPattern(segment):
+		c, ok := n.globs[segment]
+		if !ok && create {
+			c = newPatternNode()
+			n.globs[segment] = c
+		}
+		return c
+	default:
+		c, ok := n.literals[segment]
+		if !ok && create {
+			c = newPatternNode()
+			n.literals[segment] = c
+		}
+		return c
+	}
+}
+
+// insert 會依照樣式建立節點並回傳其終點節點。
+func (n *patternNode) insert(pattern string) *patternNode {
+	node := n
+	for _, v := range strings.Split(pattern, ".") {
+		node = node.child(v, true)
+	}
+	return node
+}
+
+// find 會回傳指定樣式的終點節點，不存在時回傳 `nil`。
+func (n *patternNode) find(pattern string) *patternNode {
+	node := n
+	for _, v := range strings.Split(pattern, ".") {
+		if node = node.child(v, false); node == nil {
+			return nil
+		}
+	}
+	return node
+}
+
+// delete 會移除指定樣式所保存的資料，並一併清除已經沒有用途的節點。
+func (n *patternNode) delete(pattern string) {
+	n.prune(strings.Split(pattern, "."))
+}
+
+// prune 會遞迴地移除指定段落的資料，並回傳此節點是否已經是空的。
+func (n *patternNode) prune(segments []string) bool {
+	if len(segments) == 0 {
+		n.value = nil
+	} else if c := n.child(segments[0], false); c != nil && c.prune(segments[1:]) {
+		switch {
+		case segments[0] == ">":
+			n.tail = nil
+		case IsPattern(segments[0]):
+			delete(n.globs, segments[0])
+		default:
+			delete(n.literals, segments[0])
+		}
+	}
+	return n.empty()
+}
+
+// empty 會回傳此節點是否已經沒有任何資料與子節點。
+func (n *patternNode) empty() bool {
+	return n.value == nil && n.tail == nil && len(n.literals) == 0 && len(n.globs) == 0
+}
+
+// match 會找出所有符合指定名稱的終點節點，並將其資料傳入回呼函式。
+func (n *patternNode) match(name string, fn func(interface{})) {
+	n.walk(strings.Split(name, "."), fn)
+}
+
+// walk 會沿著名稱的段落走訪索引樹。
+func (n *patternNode) walk(segments []string, fn func(interface{})) {
+	if len(segments) == 0 {
+		if n.value != nil {
+			fn(n.value)
+		}
+		return
+	}
+	if c, ok := n.literals[segments[0]]; ok {
+		c.walk(segments[1:], fn)
+	}
+	for p, c := range n.globs {
+		if ok, _ := path.Match(p, segments[0]); ok {
+			c.walk(segments[1:], fn)
+		}
+	}
+	if n.tail != nil && n.tail.value != nil {
+		fn(n.tail.value)
+	}
+}
+
+// each 會將索引樹中所有終點節點的資料傳入回呼函式。
+func (n *patternNode) each(fn func(interface{})) {
+	if n.value != nil {
+		fn(n.value)
+	}
+	for _, c := range n.literals {
+		c.each(fn)
+	}
+	for _, c := range n.globs {
+		c.each(fn)
+	}
+	if n.tail != nil {
+		n.tail.each(fn)
+	}
+}
+
+// subscribePattern 會替傳入的階段訂閱以萬用字元樣式表示的事件與頻道。
+func (e *Engine) subscribePattern(sess *Session, evtName string, chName string, presence interface{}) {
+	e.lock.Lock()
+	// 事件樣式的終點節點保存著另一棵頻道樣式的索引樹。
+	evtNode := e.patterns.insert(evtName)
+	if evtNode.value == nil {
+		evtNode.value = newPatternNode()
+	}
+	chNode := evtNode.value.(*patternNode).insert(chName)
+	if chNode.value == nil {
+		chNode.value = &Channel{
+			Name: chName,
+			Event: &Event{
+				Name:     evtName,
+				Channels: make(map[string]*Channel),
+				Option:   &EventOption{},
+				engine:   e,
+			},
+		}
+	}
+	ch := chNode.value.(*Channel)
+	// 必須在持有引擎鎖時加入訂閱者，否則頻道可能在此之前就因為沒有訂閱者而被其他取消訂閱移出索引。
+	added, _ := ch.add(sess, presence, 0)
+	// 找出所有符合樣式的頻道，讓新的訂閱者能先收到其共享狀態的快照。
+	var states []*Channel
+	if added {
+		for name, evt := range e.Events {
+			if !Match(evtName, name) {
+				continue
+			}
+			for name, v := range evt.Channels {
+				if Match(chName, name) {
+					states = append(states, v)
+				}
+			}
+		}
+	}
+	e.lock.Unlock()
+
+	if added {
+		ch.announce("MegoJoin", sess, presence)
+		for _, v := range states {
+			if v.Version() > 0 {
+				v.snapshot(sess)
+			}
+		}
+	}
+}
+
+// unsubscribePattern 會替傳入的階段取消訂閱以萬用字元樣式表示的事件與頻道，
+// 沒有訂閱者的樣式會從索引中移除。
+func (e *Engine) unsubscribePattern(sess *Session, evtName string, chName string) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	evtNode := e.patterns.find(evtName)
+	if evtNode == nil || evtNode.value == nil {
+		return
+	}
+	chTree := evtNode.value.(*patternNode)
+	chNode := chTree.find(chName)
+	if chNode == nil || chNode.value == nil {
+		return
+	}
+	ch := chNode.value.(*Channel)
+	if presence, ok := ch.remove(sess); ok {
+		ch.announce("MegoLeave", sess, presence)
+	}
+	e.prunePattern(ch)
+}
+
+// prunePattern 會在樣式頻道沒有任何訂閱者時將其從索引中移除，呼叫前必須先取得引擎的寫入鎖。
+func (e *Engine) prunePattern(ch *Channel) {
+	if len(ch.sessions()) != 0 {
+		return
+	}
+	evtNode := e.patterns.find(ch.Event.Name)
+	if evtNode == nil || evtNode.value == nil {
+		return
+	}
+	chTree := evtNode.value.(*patternNode)
+	chTree.delete(ch.Name)
+	if chTree.empty() {
+		e.patterns.delete(ch.Event.Name)
+	}
+}
+
+// matchPatterns 會透過索引找出所有符合指定事件與頻道的樣式頻道。
+func (e *Engine) matchPatterns(evtName string, chName string) []*Channel {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	var list []*Channel
+	e.patterns.match(evtName, func(v interface{}) {
+		v.(*patternNode).match(chName, func(v interface{}) {
+			list = append(list, v.(*Channel))
+		})
+	})
+	return list
+}
+
+// patternChannels 會回傳所有的樣式頻道。
+func (e *Engine) patternChannels() []*Channel {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	var list []*Channel
+	e.patterns.each(func(v interface{}) {
+		v.(*patternNode).each(func(v interface{}) {
+			list = append(list, v.(*Channel))
+		})
+	})
+	return list
+}
@@ -0,0 +1,107 @@
+package mego
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		expect  bool
+	}{
+		{"orders", "orders", true},
+		{"orders", "order", false},
+		{"*", "orders", true},
+		{"*", "orders.a", false},
+		{"device.*.status", "device.a.status", true},
+		{"device.*.status", "device.a.b.status", false},
+		{"device.?.status", "device.ab.status", false},
+		{"device.[ab].status", "device.b.status", true},
+		{"device.>", "device.a", true},
+		{"device.>", "device.a.b", true},
+		{"device.>", "device", false},
+		{">", "orders", true},
+		{"device.>.status", "device.>.status", true},
+		{"device.>.status", "device.a.status", false},
+	}
+	for _, v := range tests {
+		assert.Equal(t, v.expect, Match(v.pattern, v.name), v.pattern+" "+v.name)
+	}
+}
+
+func TestPatternNode(t *testing.T) {
+	root := newPatternNode()
+	for _, v := range []string{"device.*.status", "device.>", "device.a.status", "*", "orders.[ab]"} {
+		root.insert(v).value = v
+	}
+	tests := []struct {
+		name   string
+		expect []string
+	}{
+		{"device.a.status", []string{"device.*.status", "device.>", "device.a.status"}},
+		{"device.b.status", []string{"device.*.status", "device.>"}},
+		{"device.b", []string{"device.>"}},
+		{"device", []string{"*"}},
+		{"orders.a", []string{"orders.[ab]"}},
+		{"orders.c", nil},
+	}
+	for _, v := range tests {
+		var got []string
+		root.match(v.name, func(value interface{}) {
+			got = append(got, value.(string))
+		})
+		sort.Strings(got)
+		sort.Strings(v.expect)
+		assert.Equal(t, v.expect, got, v.name)
+	}
+
+	// 移除樣式後，沒有用途的節點也會一併被清除。
+	assert.Equal(t, "device.>", root.find("device.>").value)
+	root.delete("device.>")
+	root.delete("device.*.status")
+	root.delete("device.a.status")
+	assert.Nil(t, root.find("device.>"))
+	_, ok := root.literals["device"]
+	assert.False(t, ok)
+	root.delete("*")
+	root.delete("orders.[ab]")
+	assert.True(t, root.empty())
+}
+
+func TestSubscribePatternOptions(t *testing.T) {
+	e, sess, _ := newTestEngine()
+	e.Event("orders", EventOption{
+		RequireKeys: []string{"UserID"},
+	})
+	e.Event("rooms", EventOption{
+		MaxSubscribers: 10,
+	})
+	e.Event("secrets", EventOption{
+		SubscribeHandler: func(evt string, ch string, ctx *Context) bool {
+			return false
+		},
+	})
+	subscribe := func(evt, ch string) bool {
+		e.subscribeRequest(&Context{
+			Session: sess,
+			data:    []interface{}{evt, ch},
+			engine:  e,
+		})
+		return len(e.matchPatterns("orders", "a")) != 0 || len(e.matchPatterns("rooms", "a")) != 0 || len(e.matchPatterns("secrets", "a")) != 0
+	}
+
+	// 萬用字元訂閱必須遵守所有符合樣式的事件選項。
+	assert.False(t, subscribe("*", "a"))
+	assert.False(t, subscribe("orders", "*"))
+	sess.Set("UserID", 1)
+	assert.True(t, subscribe("orders", "*"))
+	e.unsubscribe(sess, "orders", "*")
+	assert.False(t, subscribe("rooms", "*"))
+	assert.False(t, subscribe("secrets", ">"))
+	assert.False(t, subscribe("[or]*", "a"))
+	assert.True(t, subscribe("o*", "a"))
+}
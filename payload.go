@@ -10,6 +10,8 @@ type Request struct {
 	Params interface{} `codec:"p" msgpack:"p"`
 	// ID 為本次請求編號，若無則為單次通知廣播不需回應。
 	ID int `codec:"i" msgpack:"i"`
+	// Range 是下載檔案時所要求的位元組範圍，索引 0 為起始位置，索引 1 則是可選的結束位置（不包含）。
+	Range []int64 `codec:"r" msgpack:"r"`
 }
 
 // Response 呈現了 Mego 將會回應給客戶端的內容。
@@ -22,6 +24,12 @@ type Response struct {
 	Error ResponseError `codec:"e" msgpack:"e"`
 	// ID 是當時發送此請求的編號，用以讓客戶端比對是哪個請求所造成的回應。
 	ID int `codec:"i" msgpack:"i"`
+	// Channel 是廣播此事件的頻道名稱。
+	Channel string `codec:"c" msgpack:"c"`
+	// Sequence 是此事件在頻道中的遞增序號，客戶端能以此察覺是否有遺漏的事件。
+	Sequence int `codec:"s" msgpack:"s"`
+	// Delivery 是需要客戶端確認收到的投遞編號，客戶端處理完此事件後應該以此編號回報。
+	Delivery string `codec:"d" msgpack:"d"`
 }
 
 // ResponseError 是回應錯誤資料建構體。
@@ -34,6 +42,11 @@ type ResponseError struct {
 	Data interface{} `codec:"d" msgpack:"d"`
 }
 
+// Error 會回傳錯誤訊息，讓檔案處理函式等能以 `ResponseError` 作為錯誤回傳，並以其狀態碼回應客戶端。
+func (r ResponseError) Error() string {
+	return r.Message
+}
+
 // RawFile 是尚未轉化成為可供開發者使用之前的生檔案資料內容。
 type RawFile struct {
 	// Binary 是檔案的二進制。
@@ -45,4 +58,14 @@ type RawFile struct {
 	Parts []int `codec:"p" msgpack:"p"`
 	// Name 是檔案的原始名稱。
 	Name string `codec:"n" msgpack:"n"`
+	// Upload 是由客戶端產生的上傳編號，區塊上傳在斷線重連後能以此編號繼續上傳。
+	Upload string `codec:"u" msgpack:"u"`
+	// Size 是整個檔案的位元組大小，用於計算上傳進度。
+	Size int64 `codec:"s" msgpack:"s"`
+	// Offset 是本區塊在整個檔案中的位元組位置，區塊會被寫入至此位置，因此能以任意順序上傳。
+	Offset int64 `codec:"o" msgpack:"o"`
+	// Hash 是本區塊（或整個檔案）內容以 `演算法:十六進位雜湊` 表示的雜湊，例如：`crc32c:1a2b3c4d`。
+	Hash string `codec:"h" msgpack:"h"`
+	// Digest 是整個檔案以 `sha256:十六進位雜湊` 表示的摘要，會在檔案接收完畢後、呼叫方法處理函式前檢查。
+	Digest string `codec:"g" msgpack:"g"`
 }
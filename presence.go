@@ -0,0 +1,63 @@
+package mego
+
+import "github.com/vmihailenco/msgpack"
+
+// Member 呈現了頻道中的一個訂閱者與其在線資料。
+type Member struct {
+	// ID 是此訂閱者的階段編號。
+	ID string
+	// Presence 是此訂閱者在頻道中的自訂在線資料，例如：暱稱、狀態。
+	Presence interface{}
+}
+
+// Members 會回傳目前訂閱此頻道的所有成員與其在線資料。
+func (c *Channel) Members() []Member {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	members := make([]Member, len(c.Sessions))
+	for i, v := range c.Sessions {
+		members[i] = Member{
+			ID:       v.ID,
+			Presence: c.presences[v],
+		}
+	}
+	return members
+}
+
+// SetPresence 會更改指定階段在此頻道中的在線資料，如果該階段沒有訂閱此頻道則回傳 `false`。
+func (c *Channel) SetPresence(id string, presence interface{}) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, v := range c.Sessions {
+		if v.ID == id {
+			c.presences[v] = presence
+			return true
+		}
+	}
+	return false
+}
+
+// announce 會在引擎開啟在線狀態追蹤時，將指定階段加入或離開頻道的系統事件廣播給頻道中的其他訂閱者。
+func (c *Channel) announce(name string, sess *Session, presence interface{}) {
+	if c.Event == nil || c.Event.engine == nil || !c.Event.engine.Option.Presence {
+		return
+	}
+	msg, err := msgpack.Marshal(Response{
+		Event:   name,
+		Channel: c.Name,
+		Result: H{
+			"Event":    c.Event.Name,
+			"Channel":  c.Name,
+			"ID":       sess.ID,
+			"Presence": presence,
+		},
+	})
+	if err != nil {
+		return
+	}
+	for _, v := range c.sessions() {
+		if v != sess {
+			v.writeBinary(msg)
+		}
+	}
+}
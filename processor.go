@@ -0,0 +1,176 @@
+package mego
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	// DefaultProcessTimeout 是檔案處理函式預設最多能執行的秒數。
+	DefaultProcessTimeout = 30
+)
+
+// FileProcessor 是檔案組合完畢後、呼叫方法處理函式前所執行的檔案處理函式，例如掃毒、移除 EXIF 或是去除重複的檔案。
+// 回傳的檔案會取代原本的檔案，回傳 `nil` 則沿用原本的檔案。處理函式能在 `File.Keys` 中存放處理的結果供方法處理函式取得。
+// 回傳 `ResponseError` 會以其狀態碼拒絕此請求，其他的錯誤則會以 `StatusError` 拒絕。
+type FileProcessor func(c *Context, field string, f *File) (*File, error)
+
+// FileProcessor 會新增一個全域的檔案處理函式，全域的檔案處理函式會在方法的檔案處理函式之前依序執行。
+func (e *Engine) FileProcessor(processor FileProcessor) *Engine {
+	e.fileProcessors = append(e.fileProcessors, processor)
+	return e
+}
+
+// FileProcessor 會新增一個此方法的檔案處理函式，會在全域的檔案處理函式之後依序執行。
+func (m *Method) FileProcessor(processor FileProcessor) *Method {
+	m.FileProcessors = append(m.FileProcessors, processor)
+	return m
+}
+
+// processTimeout 會回傳檔案處理函式最多能執行的時間，方法的選項會覆蓋引擎設定。
+func (e *Engine) processTimeout(m *Method) time.Duration {
+	if m != nil && m.Option != nil && m.Option.ProcessTimeout != 0 {
+		return time.Second * time.Duration(m.Option.ProcessTimeout)
+	}
+	if e.Option.ProcessTimeout == 0 {
+		return time.Second * time.Duration(DefaultProcessTimeout)
+	}
+	return time.Second * time.Duration(e.Option.ProcessTimeout)
+}
+
+// processJob 是一個正在交由檔案處理函式處理的檔案。
+type processJob struct {
+	// field 是檔案所屬的檔案欄位名稱。
+	field string
+	// index 是檔案在檔案欄位中的索引。
+	index int
+	// file 是處理後的檔案。
+	file *File
+	// err 是檔案被拒絕的原因。
+	err error
+	// done 表示此檔案已經處理完畢。
+	done bool
+}
+
+// processFiles 會將上下文中的每個檔案交由全域與方法的檔案處理函式處理，並在所有檔案都處理完畢後回傳 `true` 讓請求繼續執行。
+// 每個檔案都會在各自的 Goroutine 中以上下文的複本處理，有檔案被拒絕或是超過處理時限時會回應客戶端並回傳 `false`。
+// 逾時的時候仍在處理中的檔案不會被放回上下文，而是由處理完畢的 Goroutine 自行移除，避免請求結束時移除正在被處理的檔案。
+func (e *Engine) processFiles(c *Context) bool {
+	var processors []FileProcessor
+	processors = append(processors, e.fileProcessors...)
+	processors = append(processors, c.Method.FileProcessors...)
+	if len(processors) == 0 {
+		return true
+	}
+	var jobs []*processJob
+	for field, files := range c.files {
+		for i, f := range files {
+			jobs = append(jobs, &processJob{
+				field: field,
+				index: i,
+				file:  f,
+			})
+		}
+	}
+	if len(jobs) == 0 {
+		return true
+	}
+
+	var (
+		wg      sync.WaitGroup
+		lock    sync.Mutex
+		expired bool
+	)
+	for _, j := range jobs {
+		wg.Add(1)
+		go func(j *processJob, ctx *Context) {
+			defer wg.Done()
+			f, err := runProcessors(ctx, processors, j.field, j.file)
+			lock.Lock()
+			defer lock.Unlock()
+			// 逾時後才處理完畢的檔案已經不會被使用，也不在上下文中，因此直接移除。
+			if expired {
+				if !f.claimed {
+					f.Remove()
+				}
+				return
+			}
+			j.file, j.err, j.done = f, err, true
+		}(j, c.Copy())
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	timeout := make(chan struct{})
+	timer := e.clock().AfterFunc(e.processTimeout(c.Method), func() {
+		close(timeout)
+	})
+
+	select {
+	case <-done:
+		timer.Stop()
+	case <-timeout:
+		lock.Lock()
+		expired = true
+		// 只有已經處理完畢的檔案會放回上下文中，並在請求結束後被移除，仍在處理中的檔案則交給其 Goroutine 移除。
+		files := make(map[string][]*File)
+		for _, j := range jobs {
+			if j.done {
+				files[j.field] = append(files[j.field], j.file)
+			}
+		}
+		c.files = files
+		lock.Unlock()
+		c.RespondWithError(StatusTimeout, nil, ErrProcessTimeout)
+		return false
+	}
+
+	for _, j := range jobs {
+		c.files[j.field][j.index] = j.file
+	}
+	for _, j := range jobs {
+		if j.err == nil {
+			continue
+		}
+		switch v := j.err.(type) {
+		case ResponseError:
+			c.RespondWithError(v.Code, v.Data, v)
+		case *ResponseError:
+			c.RespondWithError(v.Code, v.Data, v)
+		default:
+			c.RespondWithError(StatusError, nil, v)
+		}
+		return false
+	}
+	return true
+}
+
+// runProcessors 會將檔案依序交由每個檔案處理函式處理並回傳最後的檔案，被取代的檔案會直接被移除。
+// 檔案處理函式在 Goroutine 中執行，因此發生 `panic` 時會被轉換成錯誤，而不會導致整個伺服器終止。
+func runProcessors(c *Context, processors []FileProcessor, field string, f *File) (file *File, err error) {
+	file = f
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("mego: the file processor panicked: %v", r)
+		}
+	}()
+	for _, p := range processors {
+		if file.Keys == nil {
+			file.Keys = make(map[string]interface{})
+		}
+		next, err := p(c, field, file)
+		if next != nil && next != file {
+			if !file.claimed {
+				file.Remove()
+			}
+			file = next
+		}
+		if err != nil {
+			return file, err
+		}
+	}
+	return file, nil
+}
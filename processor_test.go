@@ -0,0 +1,165 @@
+package mego
+
+import (
+	"errors"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmihailenco/msgpack"
+)
+
+// newTestFile 會在儲存裝置中建立一個空白檔案。
+func newTestFile(store FileStore, name string) *File {
+	key, _ := store.Create(name)
+	return newFile(store, key, name, 0)
+}
+
+// lastError 會回傳階段最後收到的錯誤回應狀態碼。
+func lastError(t *testing.T, sess *Session) int {
+	sess.lock.Lock()
+	defer sess.lock.Unlock()
+	if len(sess.queue) == 0 {
+		return 0
+	}
+	var resp Response
+	assert.NoError(t, msgpack.Unmarshal(sess.queue[len(sess.queue)-1], &resp))
+	return resp.Error.Code
+}
+
+func TestProcessorOrder(t *testing.T) {
+	e, sess, store := newTestEngine()
+	var order []string
+	record := func(name string) FileProcessor {
+		return func(c *Context, field string, f *File) (*File, error) {
+			order = append(order, name)
+			return nil, nil
+		}
+	}
+	e.FileProcessor(record("engine 1")).FileProcessor(record("engine 2"))
+	m := &Method{}
+	m.FileProcessor(record("method 1")).FileProcessor(record("method 2"))
+
+	c := newTestContext(e, sess, m)
+	c.files["File"] = []*File{newTestFile(store, "a.txt")}
+	assert.True(t, e.processFiles(c))
+	assert.Equal(t, []string{"engine 1", "engine 2", "method 1", "method 2"}, order)
+}
+
+func TestProcessorReplace(t *testing.T) {
+	e, sess, store := newTestEngine()
+	var replacement *File
+	m := &Method{}
+	m.FileProcessor(func(c *Context, field string, f *File) (*File, error) {
+		f.Keys["Scanned"] = true
+		replacement = newTestFile(store, "b.txt")
+		return replacement, nil
+	}).FileProcessor(func(c *Context, field string, f *File) (*File, error) {
+		// 後續的處理函式會收到被取代後的檔案。
+		assert.Equal(t, replacement, f)
+		return f, nil
+	})
+
+	c := newTestContext(e, sess, m)
+	original := newTestFile(store, "a.txt")
+	c.files["File"] = []*File{original}
+	assert.True(t, e.processFiles(c))
+	assert.Equal(t, replacement, c.files["File"][0])
+	// 被取代的檔案會直接被移除。
+	assert.NotContains(t, store.files, original.Key)
+	assert.Contains(t, store.files, replacement.Key)
+}
+
+func TestProcessorReject(t *testing.T) {
+	tests := []struct {
+		name      string
+		processor FileProcessor
+		code      int
+	}{
+		{"response error", func(c *Context, field string, f *File) (*File, error) {
+			return nil, ResponseError{Code: StatusNoPermission, Message: "infected"}
+		}, StatusNoPermission},
+		{"response error pointer", func(c *Context, field string, f *File) (*File, error) {
+			return nil, &ResponseError{Code: StatusInvalid, Message: "invalid"}
+		}, StatusInvalid},
+		{"other error", func(c *Context, field string, f *File) (*File, error) {
+			return nil, errors.New("failed")
+		}, StatusError},
+		{"panic", func(c *Context, field string, f *File) (*File, error) {
+			panic("failed")
+		}, StatusError},
+	}
+	for _, v := range tests {
+		e, sess, store := newTestEngine()
+		called := false
+		m := &Method{}
+		m.FileProcessor(v.processor).FileProcessor(func(c *Context, field string, f *File) (*File, error) {
+			called = true
+			return nil, nil
+		})
+		c := newTestContext(e, sess, m)
+		c.files["File"] = []*File{newTestFile(store, "a.txt")}
+		assert.False(t, e.processFiles(c), v.name)
+		// 被拒絕後就不會再交由之後的處理函式處理。
+		assert.False(t, called, v.name)
+		assert.Equal(t, v.code, lastError(t, sess), v.name)
+	}
+}
+
+func TestProcessorTimeout(t *testing.T) {
+	e, sess, store := newTestEngine()
+	e.Option.ProcessTimeout = 5
+	release := make(chan struct{})
+	read := make(chan error, 1)
+	m := &Method{}
+	m.FileProcessor(func(c *Context, field string, f *File) (*File, error) {
+		<-release
+		// 逾時後仍在處理中的檔案不會被請求結束時的清理移除。
+		r, err := f.Open()
+		if err == nil {
+			_, err = ioutil.ReadAll(r)
+			r.Close()
+		}
+		read <- err
+		return nil, nil
+	})
+	c := newTestContext(e, sess, m)
+	f := newTestFile(store, "a.txt")
+	assert.NoError(t, store.Append(f.Key, []byte("content")))
+	c.files["File"] = []*File{f}
+
+	// 處理函式開始執行後計時器才會被建立，因此持續推進時間直到逾時。
+	finished := make(chan bool)
+	go func() {
+		finished <- e.processFiles(c)
+	}()
+	var ok bool
+loop:
+	for {
+		select {
+		case ok = <-finished:
+			break loop
+		default:
+			e.Option.Clock.(*FakeClock).Advance(time.Second)
+			time.Sleep(time.Millisecond)
+		}
+	}
+	assert.False(t, ok)
+	assert.Equal(t, StatusTimeout, lastError(t, sess))
+	e.cleanFiles(c)
+
+	close(release)
+	assert.NoError(t, <-read)
+	// 處理完畢後，已經不會被使用的檔案會由處理的 Goroutine 移除。
+	removed := func() bool {
+		store.lock.RLock()
+		defer store.lock.RUnlock()
+		_, ok := store.files[f.Key]
+		return !ok
+	}
+	for i := 0; i < 100 && !removed(); i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	assert.True(t, removed())
+}
@@ -0,0 +1,55 @@
+package mego
+
+// PublishHandler 是客戶端發布事件的處理函式。能回傳改寫過的資料酬載，或是回傳 `false` 拒絕此次發布。
+type PublishHandler func(evt string, ch string, ctx *Context, payload interface{}) (interface{}, bool)
+
+// HandlePublish 會設置客戶端發布事件的處理函式，開發者可以在此檢查、改寫客戶端欲廣播的資料。
+// 沒有設置處理函式時，所有來自客戶端的發布請求都會被拒絕。
+func (e *Engine) HandlePublish(handler PublishHandler) *Engine {
+	e.publishHandler = handler
+	return e
+}
+
+// publishRequest 會處理來自客戶端的發布請求，並將結果或被拒的原因回應給客戶端。
+func (e *Engine) publishRequest(ctx *Context) {
+	// 索引 0 為事件名稱、索引 1 為頻道名稱、索引 2 為資料酬載，索引 3 則表示是否略過發布者本身。
+	evt := ctx.Param(0).GetString()
+	ch := ctx.Param(1).GetString()
+	payload := ctx.Param(2).Get()
+	others := ctx.Param(3).GetBool()
+
+	// refuse 會告知客戶端發布被拒，除非處理函式已經自行回應了被拒的原因。
+	refuse := func(code int, err error) {
+		if ctx.ID != 0 && !ctx.responded {
+			ctx.RespondWithError(code, nil, err)
+		}
+	}
+
+	// 不能向萬用字元樣式發布事件。
+	if IsPattern(evt) || IsPattern(ch) {
+		refuse(StatusInvalid, ErrPublishRefused)
+		return
+	}
+	if e.publishHandler == nil {
+		refuse(StatusNoPermission, ErrPublishRefused)
+		return
+	}
+	payload, ok := e.publishHandler(evt, ch, ctx, payload)
+	if !ok {
+		refuse(StatusNoPermission, ErrPublishRefused)
+		return
+	}
+
+	var exclude *Session
+	if others {
+		exclude = ctx.Session
+	}
+	if err := e.emit(evt, ch, payload, exclude, nil, e.eventOption(evt).Acknowledge); err != nil {
+		refuse(StatusNotFound, err)
+		return
+	}
+	// 有請求編號的話就回應客戶端已發布成功。
+	if ctx.ID != 0 {
+		ctx.Respond(nil)
+	}
+}
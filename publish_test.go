@@ -0,0 +1,58 @@
+package mego
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmihailenco/msgpack"
+)
+
+func TestPublishOthersReplay(t *testing.T) {
+	e, sess, _ := newTestEngine()
+	other := &Session{
+		ID:       "other",
+		engine:   e,
+		detached: true,
+	}
+	e.Sessions[other.ID] = other
+	e.Option.HistoryLength = 10
+	e.Event("Event").Channel("Channel")
+	e.HandlePublish(func(evt string, ch string, ctx *Context, payload interface{}) (interface{}, bool) {
+		return payload, true
+	})
+	assert.NoError(t, e.subscribe(sess, "Event", "Channel", nil, false))
+	assert.NoError(t, e.subscribe(other, "Event", "Channel", nil, false))
+
+	publish := func(payload int, others bool) {
+		e.publishRequest(&Context{
+			Session: sess,
+			data:    []interface{}{"Event", "Channel", payload, others},
+			engine:  e,
+		})
+	}
+	publish(1, false)
+	publish(2, true)
+	assert.NoError(t, e.Emit("Event", "Channel", 3))
+
+	sequences := func(s *Session) (list []int) {
+		for _, msg := range s.queue {
+			var resp Response
+			assert.NoError(t, msgpack.Unmarshal(msg, &resp))
+			list = append(list, resp.Sequence)
+		}
+		return
+	}
+	assert.Equal(t, []int{1, 3}, sequences(sess))
+	assert.Equal(t, []int{1, 2, 3}, sequences(other))
+
+	// 發布者察覺序號有缺漏而要求補收時，也不會收到自己略過的事件。
+	sess.queue = nil
+	e.replay(sess, "Event", "Channel", 1, 3)
+	assert.Len(t, sess.queue, 0)
+	e.replay(sess, "Event", "Channel", 0, 0)
+	assert.Equal(t, []int{1, 3}, sequences(sess))
+
+	other.queue = nil
+	e.replay(other, "Event", "Channel", 0, 0)
+	assert.Equal(t, []int{1, 2, 3}, sequences(other))
+}
@@ -0,0 +1,273 @@
+package mego
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NewS3FileStore 會建立一個將檔案存放至 S3 相容物件儲存的儲存裝置。
+// `endpoint` 是物件儲存的網址，例如：`https://s3.amazonaws.com` 或本地的 `http://localhost:9000`。
+func NewS3FileStore(endpoint string, region string, bucket string, accessKey string, secretKey string) *S3FileStore {
+	return &S3FileStore{
+		Endpoint:  endpoint,
+		Region:    region,
+		Bucket:    bucket,
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+	}
+}
+
+// S3FileStore 是將檔案存放至 S3 相容物件儲存的儲存裝置，請求會以 AWS Signature Version 4 簽署並使用路徑形式的網址。
+// 上傳中的區塊會先暫存在 `Staging` 中，直到檔案完成寫入時才直接從暫存的檔案串流上傳至物件儲存，而不會再複製一份到記憶體中。
+type S3FileStore struct {
+	// Endpoint 是物件儲存的網址。
+	Endpoint string
+	// Region 是物件儲存的區域，例如：`us-east-1`。
+	Region string
+	// Bucket 是存放檔案的儲存貯體名稱。
+	Bucket string
+	// AccessKey 是存取金鑰編號。
+	AccessKey string
+	// SecretKey 是存取金鑰密碼。
+	SecretKey string
+	// Client 是發送請求時所使用的 HTTP 客戶端，未指定時使用 `http.DefaultClient`。
+	Client *http.Client
+	// Staging 是暫存上傳中區塊的儲存裝置，未指定時使用系統暫存資料夾中 `mego-s3` 資料夾的 `LocalFileStore`。
+	Staging FileStore
+
+	// lock 是初始化暫存儲存裝置時的互斥鎖。
+	lock sync.Mutex
+}
+
+// staging 會回傳暫存上傳中區塊的儲存裝置。
+func (s *S3FileStore) staging() FileStore {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.Staging == nil {
+		dir := filepath.Join(os.TempDir(), "mego-s3")
+		os.MkdirAll(dir, 0700)
+		s.Staging = &LocalFileStore{
+			Dir: dir,
+		}
+	}
+	return s.Staging
+}
+
+// Create 會在暫存儲存裝置中建立一個空白檔案，其鍵名同時也會是物件儲存中的鍵名。
+func (s *S3FileStore) Create(name string) (string, error) {
+	return s.staging().Create(name)
+}
+
+// Append 會將區塊內容附加至暫存的檔案。
+func (s *S3FileStore) Append(key string, chunk []byte) error {
+	return s.staging().Append(key, chunk)
+}
+
+// WriteAt 會將區塊內容寫入至暫存檔案的指定位置。
+func (s *S3FileStore) WriteAt(key string, chunk []byte, offset int64) error {
+	return s.staging().WriteAt(key, chunk, offset)
+}
+
+// Finalize 會將暫存的檔案上傳至物件儲存，並移除暫存的檔案。
+// 簽署所需的雜湊會先讀取一次暫存的檔案計算，接著再從暫存的檔案串流上傳。
+func (s *S3FileStore) Finalize(key string) (int64, error) {
+	staging := s.staging()
+	size, err := staging.Finalize(key)
+	if err != nil {
+		return 0, err
+	}
+	r, err := staging.Open(key)
+	if err != nil {
+		return 0, err
+	}
+	h := sha256.New()
+	_, err = io.Copy(h, r)
+	r.Close()
+	if err != nil {
+		return 0, err
+	}
+	r, err = staging.Open(key)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	resp, err := s.do("PUT", key, r, size, hex.EncodeToString(h.Sum(nil)), nil)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return size, staging.Remove(key)
+}
+
+// Open 會從物件儲存下載指定檔案供讀取，尚未完成寫入的檔案則會從暫存儲存裝置中讀取。
+func (s *S3FileStore) Open(key string) (io.ReadCloser, error) {
+	if r, err := s.staging().Open(key); err == nil {
+		return r, nil
+	}
+	resp, err := s.do("GET", key, nil, 0, emptyHash, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Remove 會從物件儲存中移除指定檔案，尚未完成寫入的檔案則會從暫存儲存裝置中移除。
+func (s *S3FileStore) Remove(key string) error {
+	if err := s.staging().Remove(key); err == nil {
+		return nil
+	}
+	resp, err := s.do("DELETE", key, nil, 0, emptyHash, nil)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Move 會在物件儲存中將指定檔案複製至新的鍵名，並移除原本的檔案。
+func (s *S3FileStore) Move(key string, dest string) error {
+	header := http.Header{}
+	header.Set("X-Amz-Copy-Source", "/"+s.Bucket+"/"+s3Escape(key))
+	resp, err := s.do("PUT", dest, nil, 0, emptyHash, header)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	resp, err = s.do("DELETE", key, nil, 0, emptyHash, nil)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Sweep 會移除暫存儲存裝置中遺留的檔案，僅有暫存儲存裝置實作了 `FileSweeper` 時才有作用。
+func (s *S3FileStore) Sweep(before time.Time) error {
+	if v, ok := s.staging().(FileSweeper); ok {
+		return v.Sweep(before)
+	}
+	return nil
+}
+
+// emptyHash 是空白內容的 SHA-256 雜湊，用於沒有內容的請求。
+var emptyHash = sha256Hex(nil)
+
+// do 會簽署並發送一個物件儲存請求，回應的狀態碼不是 2xx 時會回傳錯誤。
+// 請求內容的大小與 SHA-256 雜湊必須事先計算好，如此一來內容就能以串流的方式傳送。
+func (s *S3FileStore) do(method string, key string, body io.Reader, size int64, hash string, header http.Header) (*http.Response, error) {
+	url := strings.TrimRight(s.Endpoint, "/") + "/" + s.Bucket + "/" + s3Escape(key)
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	s.sign(req, hash, time.Now().UTC())
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, fmt.Errorf("mego: s3 %s %s: %s: %s", method, key, resp.Status, msg)
+	}
+	return resp, nil
+}
+
+// sign 會以 AWS Signature Version 4 簽署請求，`hash` 是請求內容以十六進位表示的 SHA-256 雜湊。
+func (s *S3FileStore) sign(req *http.Request, hash string, now time.Time) {
+	date := now.Format("20060102")
+	stamp := now.Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", stamp)
+	req.Header.Set("X-Amz-Content-Sha256", hash)
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	// 簽署的標頭包含主機名稱與所有 `X-Amz-` 開頭的標頭，並依名稱排序。
+	headers := map[string]string{
+		"host": host,
+	}
+	for k, v := range req.Header {
+		if k := strings.ToLower(k); strings.HasPrefix(k, "x-amz-") {
+			headers[k] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonical bytes.Buffer
+	for _, v := range names {
+		canonical.WriteString(v + ":" + headers[v] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	request := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonical.String(),
+		signed,
+		hash,
+	}, "\n")
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + stamp + "\n" + scope + "\n" + sha256Hex([]byte(request))
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s.AccessKey, scope, signed, signature))
+}
+
+// s3Escape 會依照 S3 的規則編碼鍵名，除了 `/` 與非保留字元以外的字元都會被編碼。
+func s3Escape(key string) string {
+	var b strings.Builder
+	for _, c := range []byte(key) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~', c == '/':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// sha256Hex 會回傳資料以十六進位表示的 SHA-256 雜湊。
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// hmacSHA256 會以指定金鑰計算資料的 HMAC-SHA256。
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,187 @@
+package mego
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// Job 呈現了一個排程工作，可能是延遲廣播的事件或週期性執行的函式。
+type Job struct {
+	// ID 是此排程工作的不重複編號。
+	ID string
+	// Event 是延遲廣播的事件名稱，週期性工作則為空字串。
+	Event string
+	// Channel 是延遲廣播的頻道名稱，週期性工作則為空字串。
+	Channel string
+	// Spec 是週期性工作的排程規則，延遲廣播則為空字串。
+	Spec string
+
+	// engine 是建立此工作的引擎。
+	engine *Engine
+	// fn 是觸發時所執行的函式。
+	fn func()
+	// schedule 是週期性工作的排程，延遲廣播則為 `nil`。
+	schedule schedule
+	// next 是下次觸發的時間。
+	next time.Time
+	// timer 是下次觸發的計時器。
+	timer Timer
+	// cancelled 表示此工作已經被取消或已經執行完畢。
+	cancelled bool
+	// lock 是保護排程狀態的互斥鎖。
+	lock sync.Mutex
+}
+
+// Next 會回傳此工作下次觸發的時間，已經取消或執行完畢的工作會回傳零值。
+func (j *Job) Next() time.Time {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if j.cancelled {
+		return time.Time{}
+	}
+	return j.next
+}
+
+// Cancel 會取消此工作，如果工作已經被取消或已經執行完畢則回傳 `false`。
+func (j *Job) Cancel() bool {
+	j.lock.Lock()
+	if j.cancelled {
+		j.lock.Unlock()
+		return false
+	}
+	j.cancelled = true
+	j.timer.Stop()
+	j.lock.Unlock()
+
+	j.engine.unschedule(j)
+	return true
+}
+
+// start 會依照下次觸發的時間設置計時器，呼叫前必須先取得工作的鎖。
+func (j *Job) start() {
+	j.timer = j.engine.clock().AfterFunc(j.next.Sub(j.engine.clock().Now()), j.run)
+}
+
+// run 會執行此工作，週期性工作會在執行後排定下一次的觸發時間。
+func (j *Job) run() {
+	j.lock.Lock()
+	if j.cancelled {
+		j.lock.Unlock()
+		return
+	}
+	if j.schedule == nil {
+		j.cancelled = true
+		j.lock.Unlock()
+		j.engine.unschedule(j)
+		j.fn()
+		return
+	}
+	j.lock.Unlock()
+
+	j.fn()
+
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if j.cancelled {
+		return
+	}
+	j.next = j.schedule.next(j.engine.clock().Now())
+	if j.next.IsZero() {
+		j.cancelled = true
+		j.engine.unschedule(j)
+		return
+	}
+	j.start()
+}
+
+// EmitAfter 會在經過指定時間後帶有指定資料並廣播指定事件與頻道，並回傳能夠取消此次廣播的排程工作。
+func (e *Engine) EmitAfter(d time.Duration, event string, channel string, result interface{}) *Job {
+	j := &Job{
+		ID:      uuid.NewV4().String(),
+		Event:   event,
+		Channel: channel,
+		engine:  e,
+		next:    e.clock().Now().Add(d),
+	}
+	j.fn = func() {
+		e.Emit(event, channel, result)
+	}
+	e.schedule(j)
+	return j
+}
+
+// EmitAt 會在指定時間帶有指定資料並廣播指定事件與頻道，並回傳能夠取消此次廣播的排程工作。
+// 已經過去的時間會使事件立即被廣播。
+func (e *Engine) EmitAt(t time.Time, event string, channel string, result interface{}) *Job {
+	return e.EmitAfter(t.Sub(e.clock().Now()), event, channel, result)
+}
+
+// Every 會依照排程規則週期性地執行指定函式，適合用在定時刷新排行榜等週期性廣播。
+// 規則能是以空白分隔的五個 Cron 欄位（分鐘、小時、日期、月份、星期）、`@hourly` 等簡寫，或是 `@every 10s` 這樣的固定間隔。
+func (e *Engine) Every(spec string, fn func()) (*Job, error) {
+	s, err := parseSchedule(spec)
+	if err != nil {
+		return nil, err
+	}
+	next := s.next(e.clock().Now())
+	if next.IsZero() {
+		return nil, ErrInvalidSchedule
+	}
+	j := &Job{
+		ID:       uuid.NewV4().String(),
+		Spec:     spec,
+		engine:   e,
+		fn:       fn,
+		schedule: s,
+		next:     next,
+	}
+	e.schedule(j)
+	return j, nil
+}
+
+// Jobs 會依下次觸發的時間排序回傳所有尚未執行完畢的排程工作。
+func (e *Engine) Jobs() []*Job {
+	e.lock.RLock()
+	list := make([]*Job, 0, len(e.jobs))
+	for _, v := range e.jobs {
+		list = append(list, v)
+	}
+	e.lock.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Next().Before(list[j].Next())
+	})
+	return list
+}
+
+// schedule 會保存排程工作並開始計時。
+func (e *Engine) schedule(j *Job) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	e.lock.Lock()
+	if e.jobs == nil {
+		e.jobs = make(map[string]*Job)
+	}
+	e.jobs[j.ID] = j
+	e.lock.Unlock()
+
+	j.start()
+}
+
+// unschedule 會將排程工作從引擎中移除。
+func (e *Engine) unschedule(j *Job) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	delete(e.jobs, j.ID)
+}
+
+// cancelJobs 會取消所有的排程工作，用於引擎關閉時。
+func (e *Engine) cancelJobs() {
+	for _, v := range e.Jobs() {
+		v.Cancel()
+	}
+}
@@ -0,0 +1,63 @@
+package mego
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvery(t *testing.T) {
+	e := New()
+	start := time.Date(2020, 1, 1, 0, 0, 30, 0, time.UTC)
+	clock := NewFakeClock(start)
+	e.Option.Clock = clock
+
+	var runs []time.Time
+	j, err := e.Every("*/5 * * * *", func() {
+		runs = append(runs, clock.Now())
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2020, 1, 1, 0, 5, 0, 0, time.UTC), j.Next())
+
+	clock.Advance(time.Minute * 15)
+	assert.Equal(t, []time.Time{
+		time.Date(2020, 1, 1, 0, 5, 0, 0, time.UTC),
+		time.Date(2020, 1, 1, 0, 10, 0, 0, time.UTC),
+		time.Date(2020, 1, 1, 0, 15, 0, 0, time.UTC),
+	}, runs)
+	assert.Equal(t, time.Date(2020, 1, 1, 0, 20, 0, 0, time.UTC), j.Next())
+
+	// 取消後就不會再執行。
+	assert.True(t, j.Cancel())
+	assert.False(t, j.Cancel())
+	clock.Advance(time.Hour)
+	assert.Len(t, runs, 3)
+	assert.True(t, j.Next().IsZero())
+
+	_, err = e.Every("61 * * * *", func() {})
+	assert.Equal(t, ErrInvalidSchedule, err)
+}
+
+func TestJobs(t *testing.T) {
+	e := New()
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+	e.Option.Clock = clock
+
+	later := e.EmitAt(start.Add(time.Hour), "Event", "Channel", nil)
+	sooner := e.EmitAfter(time.Minute, "Event", "Channel", nil)
+	every, err := e.Every("@every 10m", func() {})
+	assert.NoError(t, err)
+	assert.Equal(t, []*Job{sooner, every, later}, e.Jobs())
+
+	// 執行完畢的一次性工作會從清單中移除。
+	clock.Advance(time.Minute * 30)
+	assert.Equal(t, []*Job{every, later}, e.Jobs())
+	assert.True(t, sooner.Next().IsZero())
+
+	// 關閉引擎時會取消所有工作。
+	e.Close()
+	assert.Len(t, e.Jobs(), 0)
+	assert.False(t, later.Cancel())
+}
@@ -1,12 +1,33 @@
 package mego
 
 import (
+	"sync"
 	"time"
 
+	"github.com/gorilla/websocket"
 	"github.com/olahol/melody"
 	"github.com/vmihailenco/msgpack"
 )
 
+const (
+	// CloseKicked 表示客戶端被伺服端踢除。
+	CloseKicked = 4000
+	// CloseBanned 表示客戶端因為被停權而斷線。
+	CloseBanned = 4001
+	// CloseDuplicateLogin 表示同個使用者在其他地方登入而使此連線被斷開。
+	CloseDuplicateLogin = 4002
+	// CloseShutdown 表示伺服端正在關閉。
+	CloseShutdown = 4003
+)
+
+// DisconnectReason 呈現了伺服端主動斷開客戶端連線的原因。
+type DisconnectReason struct {
+	// Code 是斷線原因代號，同時也會作為 WebSocket 的關閉代碼，因此必須介於 `4000` 到 `4999` 之間。
+	Code int
+	// Message 是人類可讀的斷線原因。
+	Message string
+}
+
 // Session 是接收請求時的關聯內容，其包含了指向到特定客戶端的函式。
 type Session struct {
 	// Keys 包含了發送此請求的客戶端初始連線資料，此資料由客戶端連線時自訂。可用以取得用戶身份和相關資料。
@@ -19,17 +40,79 @@ type Session struct {
 	websocket *melody.Session
 	// engine 是這個階段的父引擎。
 	engine *Engine
+	// user 是此階段所關聯的使用者編號。
+	user string
+	// resumeToken 是伺服端核發的恢復權杖，客戶端重新連線時需夾帶此權杖才能接回本階段。
+	resumeToken string
+	// detached 表示此階段的連線已經中斷，正在等待客戶端重新連線。
+	detached bool
+	// timer 是恢復期限的計時器，逾期後會正式移除此階段。
+	timer Timer
+	// queue 存放斷線期間所累積的訊息，會在重新連線後依序送出。
+	queue [][]byte
+	// lock 是保護連線狀態與暫存佇列的互斥鎖。
+	lock sync.Mutex
+	// origin 是被複製的原始階段，複製體所寫入的訊息都會交由原始階段送出。
+	origin *Session
+	// deliveries 是正在等待客戶端確認收到的投遞，以投遞編號作為鍵名。
+	deliveries map[string]*pendingDelivery
+	// closed 表示此階段已經被移除，不會再追蹤新的投遞。
+	closed bool
 }
 
-// Disconnect 會結束掉這個階段的連線。
-func (s *Session) Disconnect() error {
-	return nil
+// Disconnect 會結束掉這個階段的連線。客戶端會先接收到帶有斷線原因的 `MegoKicked` 事件，
+// 接著連線會以相對應的關閉代碼關閉。被伺服端斷線的階段不會保留恢復期限，會立即被移除。
+// 沒有傳入原因時則以 `CloseKicked` 作為原因。
+func (s *Session) Disconnect(reason ...DisconnectReason) error {
+	if s.origin != nil {
+		return s.origin.Disconnect(reason...)
+	}
+	r := DisconnectReason{
+		Code: CloseKicked,
+	}
+	if len(reason) > 0 {
+		r = reason[0]
+	}
+	if r.Code < 4000 || r.Code > 4999 {
+		r.Code = CloseKicked
+	}
+
+	// 先告知客戶端斷線的原因，讓客戶端不會自動重新連線。
+	s.write(Response{
+		Event: "MegoKicked",
+		Result: H{
+			"Code":    r.Code,
+			"Message": r.Message,
+		},
+	})
+	// 先從引擎中移除此階段，如此一來稍後的斷線處理就不會再替此階段保留恢復期限。
+	s.engine.removeSession(s)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.detached {
+		return nil
+	}
+	return s.websocket.CloseWithMsg(websocket.FormatCloseMessage(r.Code, r.Message))
 }
 
 // Copy 會複製一份 `Session` 供你在 Goroutine 中操作不會遇上資料競爭與衝突問題。
 func (s *Session) Copy() *Session {
-	sess := *s
-	return &sess
+	keys := make(map[string]interface{}, len(s.Keys))
+	for k, v := range s.Keys {
+		keys[k] = v
+	}
+	origin := s
+	if s.origin != nil {
+		origin = s.origin
+	}
+	return &Session{
+		Keys:      keys,
+		ID:        s.ID,
+		websocket: s.websocket,
+		engine:    s.engine,
+		origin:    origin,
+	}
 }
 
 // Get 會取得客戶端當初建立連線時所傳入的資料特定鍵值組。
@@ -118,7 +201,26 @@ func (s *Session) GetDuration(key string) (v time.Duration) {
 // wrtie 會將指定的回應傳入給此階段。
 func (s *Session) write(resp Response) {
 	if msg, err := msgpack.Marshal(resp); err == nil {
+		s.writeBinary(msg)
+	}
+}
+
+// writeBinary 會將已經編碼的訊息寫入此階段的 WebSocket，如果此階段正處於斷線狀態則會先暫存於佇列中。
+func (s *Session) writeBinary(msg []byte) {
+	if s.origin != nil {
+		s.origin.writeBinary(msg)
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.detached {
 		s.websocket.WriteBinary(msg)
+		return
+	}
+	s.queue = append(s.queue, msg)
+	// 超過佇列上限時就捨棄最舊的訊息。
+	if max := s.engine.Option.ResumeQueueSize; max > 0 && len(s.queue) > max {
+		s.queue = s.queue[len(s.queue)-max:]
 	}
 }
 
@@ -128,3 +230,75 @@ func (s *Session) writeOthers(resp Response) {
 		s.engine.server.websocket.BroadcastOthers(msg, s.websocket)
 	}
 }
+
+// owns 會回傳傳入的底層階段是否為此階段目前所使用的連線。
+func (s *Session) owns(ws *melody.Session) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.websocket == ws
+}
+
+// detach 會將此階段標記為斷線，並在恢復期限過後移除此階段。
+// 如果傳入的底層階段已經不是目前的連線（例如已被新的連線接回）則不做任何事。
+func (s *Session) detach(ws *melody.Session, timeout time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.websocket != ws || s.detached {
+		return
+	}
+	s.detached = true
+	s.timer = s.engine.clock().AfterFunc(timeout, func() {
+		s.lock.Lock()
+		expired := s.detached
+		s.lock.Unlock()
+		// 期限內沒有被接回才移除。
+		if expired {
+			s.engine.removeSession(s)
+		}
+	})
+}
+
+// resume 會在恢復權杖相符時將此階段接到新的底層連線上，並回傳是否成功。
+// 仍未被伺服端察覺斷線的舊連線會被關閉。接回後會先送出 `MegoResume`，再依序送出斷線期間所暫存的訊息，
+// 整個過程都持有階段的鎖，因此同時廣播的事件不會比暫存的訊息更早抵達。
+func (s *Session) resume(ws *melody.Session, token string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.resumeToken == "" || s.resumeToken != token {
+		return false
+	}
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	old := s.websocket
+	s.websocket = ws
+	s.detached = false
+	if old != nil && old != ws {
+		old.Close()
+	}
+	if msg, err := msgpack.Marshal(Response{
+		Event: "MegoResume",
+		Result: H{
+			"Token":   s.resumeToken,
+			"Resumed": true,
+		},
+	}); err == nil {
+		ws.WriteBinary(msg)
+	}
+	for _, msg := range s.queue {
+		ws.WriteBinary(msg)
+	}
+	s.queue = nil
+	return true
+}
+
+// stopTimer 會停止此階段的恢復期限計時器。
+func (s *Session) stopTimer() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+}
@@ -0,0 +1,31 @@
+package mego
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionResumeTimeout(t *testing.T) {
+	e, _, _ := newTestEngine()
+	clock := e.Option.Clock.(*FakeClock)
+	sess := &Session{
+		ID:     "resumable",
+		engine: e,
+	}
+	e.Sessions[sess.ID] = sess
+	var disconnected []string
+	e.HandleDisconnect(func(s *Session) {
+		disconnected = append(disconnected, s.ID)
+	})
+
+	// 恢復期限內的階段仍會被保留，逾期後才會被移除。
+	sess.detach(nil, time.Second*10)
+	clock.Advance(time.Second * 9)
+	assert.Contains(t, e.Sessions, sess.ID)
+	assert.Len(t, disconnected, 0)
+	clock.Advance(time.Second * 2)
+	assert.NotContains(t, e.Sessions, sess.ID)
+	assert.Equal(t, []string{sess.ID}, disconnected)
+}
@@ -0,0 +1,70 @@
+package mego
+
+// State 會回傳此頻道目前的共享狀態文件副本，修改副本並不會影響頻道的狀態，請透過 `Update` 更新。
+func (c *Channel) State() H {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	state := make(H, len(c.state))
+	for k, v := range c.state {
+		state[k] = v
+	}
+	return state
+}
+
+// Version 會回傳此頻道共享狀態的版本，每次透過 `Update` 更新時都會遞增。
+func (c *Channel) Version() int {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.version
+}
+
+// Update 會將傳入的差異合併至頻道的共享狀態，值為 `nil` 的鍵名會被移除。
+// 合併後的版本號與差異會以 `MegoState` 系統事件廣播給所有訂閱者，客戶端能以此更新本地的狀態副本。
+// 從合併到寫入完畢之間都持有頻道的傳送鎖，因此訂閱者會依照版本的順序收到差異。
+func (c *Channel) Update(patch H) error {
+	c.sendLock.Lock()
+	defer c.sendLock.Unlock()
+	c.lock.Lock()
+	if c.state == nil {
+		c.state = make(H)
+	}
+	for k, v := range patch {
+		if v == nil {
+			delete(c.state, k)
+		} else {
+			c.state[k] = v
+		}
+	}
+	c.version++
+	version := c.version
+	c.lock.Unlock()
+
+	e := c.Event.engine
+	return e.send(e.recipients(c, e.matchPatterns(c.Event.Name, c.Name)), Response{
+		Event:   "MegoState",
+		Channel: c.Name,
+		Result: H{
+			"Event":   c.Event.Name,
+			"Version": version,
+			"Patch":   patch,
+		},
+	}, false)
+}
+
+// snapshot 會將頻道目前的完整共享狀態以 `MegoSnapshot` 系統事件送給指定階段。
+func (c *Channel) snapshot(sess *Session) {
+	// 狀態在編碼前仍可能被更新，因此要在持有鎖的期間編碼；同時持有傳送鎖，避免快照插在同時更新的差異之間。
+	c.sendLock.Lock()
+	defer c.sendLock.Unlock()
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	sess.write(Response{
+		Event:   "MegoSnapshot",
+		Channel: c.Name,
+		Result: H{
+			"Event":   c.Event.Name,
+			"Version": c.version,
+			"State":   c.state,
+		},
+	})
+}
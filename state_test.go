@@ -0,0 +1,69 @@
+package mego
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmihailenco/msgpack"
+)
+
+// stateVersions 會回傳階段佇列中指定系統事件的版本號。
+func stateVersions(t *testing.T, sess *Session, event string) []int {
+	var list []int
+	for _, msg := range sess.queue {
+		var resp struct {
+			Event  string `msgpack:"v"`
+			Result struct {
+				Version int
+			} `msgpack:"r"`
+		}
+		assert.NoError(t, msgpack.Unmarshal(msg, &resp))
+		if resp.Event == event {
+			list = append(list, resp.Result.Version)
+		}
+	}
+	return list
+}
+
+func TestChannelUpdateOrder(t *testing.T) {
+	e, sess, _ := newTestEngine()
+	ch := e.Event("Event").Channel("Channel")
+	assert.NoError(t, e.subscribe(sess, "Event", "Channel", nil, false))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ch.Update(H{"Key": i})
+		}(i)
+	}
+	wg.Wait()
+
+	// 同時更新的差異仍會依照版本的順序送達。
+	versions := stateVersions(t, sess, "MegoState")
+	assert.Len(t, versions, 50)
+	for i, v := range versions {
+		assert.Equal(t, i+1, v)
+	}
+	assert.Equal(t, 50, ch.Version())
+}
+
+func TestPatternSnapshot(t *testing.T) {
+	e, sess, _ := newTestEngine()
+	assert.NoError(t, e.Event("Event").Channel("a").Update(H{"Key": 1}))
+	assert.NoError(t, e.Event("Event").Channel("b").Update(H{"Key": 2}))
+	e.Event("Event").Channel("b").Update(H{"Key": 3})
+	e.Event("Event").Channel("c")
+	e.Event("Other").Channel("a").Update(H{"Key": 4})
+
+	// 萬用字元訂閱者會收到所有符合且有共享狀態的頻道快照。
+	assert.NoError(t, e.subscribe(sess, "Event", "*", nil, false))
+	versions := stateVersions(t, sess, "MegoSnapshot")
+	assert.ElementsMatch(t, []int{1, 2}, versions)
+
+	// 重複訂閱不會再次送出快照。
+	assert.NoError(t, e.subscribe(sess, "Event", "*", nil, false))
+	assert.Len(t, stateVersions(t, sess, "MegoSnapshot"), 2)
+}
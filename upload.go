@@ -0,0 +1,340 @@
+package mego
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// DefaultUploadExpiry 是區塊上傳中斷後預設保留的秒數。
+	DefaultUploadExpiry = 60 * 60
+)
+
+// UploadProgressHandler 是每次接收到區塊時所呼叫的上傳進度處理函式，`received` 是已經接收的位元組數，
+// `total` 是客戶端所告知的檔案大小，客戶端沒有告知時為 `0`。
+type UploadProgressHandler func(c *Context, raw *RawFile, received int64, total int64)
+
+// upload 呈現了一個正在接收區塊的上傳。
+type upload struct {
+	// id 是此上傳的編號。
+	id string
+	// session 是上傳此檔案的階段編號，其他階段無法接續此上傳。
+	session string
+	// key 是檔案在儲存裝置中的鍵名。
+	key string
+	// parts 是已經寫入的區塊編號與其位元組大小，區塊能以任意順序抵達，所有區塊都到齊時上傳即完成。
+	parts map[int]int64
+	// length 是客戶端在第一個區塊所告知的檔案大小，之後的區塊都必須相同。
+	length int64
+	// count 是客戶端在第一個區塊所告知的區塊數量，之後的區塊都必須相同。
+	count int
+	// created 是此上傳開始的時間。
+	created time.Time
+	// file 是已經組合完畢的檔案，會保存到客戶端在請求中以上傳編號認領為止。
+	file *File
+	// timer 是此上傳的逾期計時器，每次接收到區塊時都會重新計時。
+	timer Timer
+	// lock 是避免同一個上傳的區塊同時被寫入的互斥鎖。
+	lock sync.Mutex
+}
+
+// uploadID 會回傳區塊所屬的上傳編號，沒有上傳編號的舊客戶端則以階段與檔案編號代替。
+func uploadID(sess *Session, raw *RawFile) string {
+	if raw.Upload != "" {
+		return raw.Upload
+	}
+	return fmt.Sprintf("%s_%d", sess.ID, raw.ID)
+}
+
+// chunkLayout 會依照區塊的編號、位置與客戶端告知的檔案大小推算切割檔案時所使用的區塊大小，並回傳區塊是否確實位於其編號所對應的位置。
+// 除了最後一個區塊以外，每個區塊的大小都是區塊大小，而最後一個區塊則剛好結束於檔案尾端，
+// 因此客戶端無法以極大的位置寫入區塊而耗盡記憶體或磁碟空間。
+func chunkLayout(raw *RawFile) (int64, bool) {
+	total, current := raw.Parts[0], raw.Parts[1]
+	n := int64(len(raw.Binary))
+	if raw.Size < 0 || raw.Offset < 0 || raw.Offset+n > raw.Size {
+		return 0, false
+	}
+	if total == 1 {
+		return raw.Size, raw.Offset == 0 && n == raw.Size
+	}
+	size := n
+	// 最後一個區塊的大小可能較小，因此以其位置推算區塊大小。
+	if current == total {
+		if raw.Offset%int64(total-1) != 0 {
+			return 0, false
+		}
+		size = raw.Offset / int64(total-1)
+		if n > size {
+			return 0, false
+		}
+	}
+	if size <= 0 || raw.Offset != int64(current-1)*size {
+		return 0, false
+	}
+	// 區塊數量必須剛好能涵蓋整個檔案。
+	if (raw.Size+size-1)/size != int64(total) {
+		return 0, false
+	}
+	return size, true
+}
+
+// uploadExpiry 會回傳區塊上傳中斷後保留的時間。
+func (e *Engine) uploadExpiry() time.Duration {
+	if e.Option.UploadExpiry == 0 {
+		return time.Second * time.Duration(DefaultUploadExpiry)
+	}
+	return time.Second * time.Duration(e.Option.UploadExpiry)
+}
+
+// upload 會取得區塊所屬的上傳，第一個區塊則會在儲存裝置中建立新的檔案。每次取得時都會重新計算逾期時間。
+func (e *Engine) upload(sess *Session, raw *RawFile) (*upload, error) {
+	id := uploadID(sess, raw)
+	e.lock.Lock()
+	u, ok := e.uploads[id]
+	e.lock.Unlock()
+
+	if !ok {
+		key, err := e.fileStore().Create(raw.Name)
+		if err != nil {
+			return nil, err
+		}
+		u = &upload{
+			id:      id,
+			session: sess.ID,
+			key:     key,
+			length:  raw.Size,
+			count:   raw.Parts[0],
+			created: e.clock().Now(),
+			parts:   make(map[int]int64),
+		}
+	} else if u.session != sess.ID {
+		return nil, ErrUploadNotFound
+	}
+	e.holdUpload(u)
+	return u, nil
+}
+
+// holdUpload 會追蹤指定的上傳並重新計算其逾期時間，逾期後上傳就會被中止。
+func (e *Engine) holdUpload(u *upload) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.uploads == nil {
+		e.uploads = make(map[string]*upload)
+	}
+	e.uploads[u.id] = u
+	e.startJanitor()
+	if u.timer != nil {
+		u.timer.Stop()
+	}
+	u.timer = e.clock().AfterFunc(e.uploadExpiry(), func() {
+		e.abortUpload(u)
+	})
+}
+
+// finishUpload 會保存已經組合完畢的區塊檔案，直到客戶端在請求中以上傳編號認領為止，逾期未被認領的檔案會被移除。
+func (e *Engine) finishUpload(sess *Session, raw *RawFile, f *File) {
+	id := uploadID(sess, raw)
+	e.lock.RLock()
+	u, ok := e.uploads[id]
+	e.lock.RUnlock()
+	// 自訂的區塊處理函式不會建立上傳，因此在這裡替其建立一個。
+	if !ok {
+		u = &upload{
+			id:      id,
+			session: sess.ID,
+			created: e.clock().Now(),
+			parts:   make(map[int]int64),
+		}
+	}
+	if f.ContentType == "" {
+		f.ContentType = sniffFile(f)
+	}
+	u.lock.Lock()
+	u.file = f
+	u.lock.Unlock()
+	e.holdUpload(u)
+}
+
+// finished 會回傳指定上傳是否已經組合完畢並等待認領。
+func (e *Engine) finished(sess *Session, id string) bool {
+	e.lock.RLock()
+	u, ok := e.uploads[id]
+	e.lock.RUnlock()
+	if !ok || u.session != sess.ID {
+		return false
+	}
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	return u.file != nil
+}
+
+// claimUpload 會取得指定上傳已經組合完畢的檔案並停止追蹤此上傳，尚未完成或屬於其他階段的上傳會回傳 `ErrUploadNotFound`。
+func (e *Engine) claimUpload(sess *Session, id string) (*File, error) {
+	e.lock.RLock()
+	u, ok := e.uploads[id]
+	e.lock.RUnlock()
+	if !ok || u.session != sess.ID {
+		return nil, ErrUploadNotFound
+	}
+	u.lock.Lock()
+	f := u.file
+	u.lock.Unlock()
+	if f == nil {
+		return nil, ErrUploadNotFound
+	}
+	e.endUpload(u)
+	return f, nil
+}
+
+// endUpload 會停止追蹤已經接收完畢的上傳。
+func (e *Engine) endUpload(u *upload) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.uploads[u.id] == u {
+		delete(e.uploads, u.id)
+	}
+	if u.timer != nil {
+		u.timer.Stop()
+	}
+}
+
+// abortUpload 會停止追蹤指定的上傳，並移除已經接收的區塊或組合完畢的檔案。用於上傳失敗或逾期時。
+func (e *Engine) abortUpload(u *upload) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	e.discardUpload(u)
+}
+
+// discardUpload 和 `abortUpload` 相同，但呼叫前必須持有上傳的鎖。
+func (e *Engine) discardUpload(u *upload) {
+	e.endUpload(u)
+	if u.file != nil {
+		u.file.Remove()
+		u.file = nil
+		return
+	}
+	e.fileStore().Remove(u.key)
+}
+
+// restartUpload 會捨棄指定上傳已經接收的內容，讓客戶端從第一個區塊重新上傳。用於整個檔案的摘要不符時。
+func (e *Engine) restartUpload(u *upload, name string) error {
+	store := e.fileStore()
+	store.Remove(u.key)
+	key, err := store.Create(name)
+	if err != nil {
+		return err
+	}
+	u.key = key
+	u.parts = make(map[int]int64)
+	return nil
+}
+
+// next 會回傳此上傳中最小的尚未接收區塊編號。
+func (u *upload) next() int {
+	n := 1
+	for {
+		if _, ok := u.parts[n]; !ok {
+			return n
+		}
+		n++
+	}
+}
+
+// received 會依序回傳此上傳已經接收的區塊編號。
+func (u *upload) received() []int {
+	parts := make([]int, 0, len(u.parts))
+	for k := range u.parts {
+		parts = append(parts, k)
+	}
+	sort.Ints(parts)
+	return parts
+}
+
+// size 會回傳此上傳已經接收的位元組數。
+func (u *upload) size() int64 {
+	var size int64
+	for _, v := range u.parts {
+		size += v
+	}
+	return size
+}
+
+// digest 會讀取上傳中已經組合的檔案並計算其 SHA-256 摘要，同時依照前幾個位元組判斷其 MIME 種類。
+// 區塊以任意順序抵達時無法邊接收邊計算，因此會在所有區塊都到齊後才讀取。
+func (e *Engine) digest(key string) (string, string, error) {
+	r, err := e.fileStore().Open(key)
+	if err != nil {
+		return "", "", err
+	}
+	defer r.Close()
+	h := newHash(HashSHA256)
+	head := make([]byte, sniffLength)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", "", err
+	}
+	h.Write(head[:n])
+	if _, err := io.Copy(h, r); err != nil {
+		return "", "", err
+	}
+	return sumHash(HashSHA256, h), sniff(head[:n]), nil
+}
+
+// uploadNext 會回傳指定上傳中最小的尚未接收區塊編號、所有已經接收的區塊編號，以及此上傳是否已經組合完畢。
+// 不存在的上傳則應該從第一個區塊開始。
+func (e *Engine) uploadNext(sess *Session, id string) (int, []int, bool) {
+	e.lock.RLock()
+	u, ok := e.uploads[id]
+	e.lock.RUnlock()
+	if !ok || u.session != sess.ID {
+		return 1, []int{}, false
+	}
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	return u.next(), u.received(), u.file != nil
+}
+
+// nextChunk 會回傳在接收指定區塊後，客戶端應該接著傳送的區塊編號。
+// 沒有被預設區塊處理函式追蹤的上傳（例如使用自訂的區塊處理函式）則一律是下一個區塊。
+func (e *Engine) nextChunk(sess *Session, raw *RawFile) int {
+	e.lock.RLock()
+	_, ok := e.uploads[uploadID(sess, raw)]
+	e.lock.RUnlock()
+	if !ok {
+		return raw.Parts[1] + 1
+	}
+	next, _, _ := e.uploadNext(sess, uploadID(sess, raw))
+	return next
+}
+
+// HandleUploadProgress 會設置上傳進度處理函式，每當區塊上傳接收到一個區塊時就會被呼叫，
+// 開發者能以此記錄上傳進度或是將進度轉發給其他階段。
+func (e *Engine) HandleUploadProgress(handler UploadProgressHandler) *Engine {
+	e.uploadProgressHandler = handler
+	return e
+}
+
+// uploadProgress 會以指定區塊所屬上傳已經接收的位元組數呼叫上傳進度處理函式。
+// 沒有被預設區塊處理函式追蹤的上傳只有在組合完畢時才能得知已經接收的位元組數。
+func (e *Engine) uploadProgress(c *Context, raw *RawFile) {
+	if e.uploadProgressHandler == nil {
+		return
+	}
+	e.lock.RLock()
+	u, ok := e.uploads[uploadID(c.Session, raw)]
+	e.lock.RUnlock()
+	if !ok {
+		return
+	}
+	u.lock.Lock()
+	received := u.size()
+	if u.file != nil {
+		received = int64(u.file.Size)
+	}
+	u.lock.Unlock()
+	e.uploadProgressHandler(c, raw, received, raw.Size)
+}
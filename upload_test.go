@@ -0,0 +1,156 @@
+package mego
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestEngine 會建立一個使用記憶體儲存與假時鐘的引擎，以及一個斷線中的階段，寫入的回應會被暫存在階段的佇列中。
+func newTestEngine() (*Engine, *Session, *MemoryFileStore) {
+	e := New()
+	store := NewMemoryFileStore()
+	e.Option.FileStore = store
+	e.Option.Clock = NewFakeClock(time.Now())
+	sess := &Session{
+		ID:       "session",
+		engine:   e,
+		detached: true,
+		Keys:     make(map[string]interface{}),
+	}
+	e.Sessions[sess.ID] = sess
+	return e, sess, store
+}
+
+// newTestContext 會建立一個呼叫指定方法的上下文建構體。
+func newTestContext(e *Engine, sess *Session, m *Method) *Context {
+	if m == nil {
+		m = &Method{}
+	}
+	return &Context{
+		Session: sess,
+		Method:  m,
+		engine:  e,
+		files:   make(map[string][]*File),
+	}
+}
+
+func TestChunkLayout(t *testing.T) {
+	tests := []struct {
+		name   string
+		raw    RawFile
+		size   int64
+		expect bool
+	}{
+		{"first", RawFile{Parts: []int{3, 1}, Binary: make([]byte, 4), Size: 10}, 4, true},
+		{"middle", RawFile{Parts: []int{3, 2}, Binary: make([]byte, 4), Offset: 4, Size: 10}, 4, true},
+		{"last", RawFile{Parts: []int{3, 3}, Binary: make([]byte, 2), Offset: 8, Size: 10}, 4, true},
+		{"single", RawFile{Parts: []int{1, 1}, Binary: make([]byte, 10), Size: 10}, 10, true},
+		{"empty", RawFile{Parts: []int{1, 1}}, 0, true},
+		{"wrong offset", RawFile{Parts: []int{3, 2}, Binary: make([]byte, 4), Offset: 5, Size: 10}, 0, false},
+		{"beyond size", RawFile{Parts: []int{3, 3}, Binary: make([]byte, 4), Offset: 8, Size: 10}, 0, false},
+		{"huge offset", RawFile{Parts: []int{2, 2}, Binary: make([]byte, 2), Offset: 1 << 40, Size: 10}, 0, false},
+		{"last larger than chunk", RawFile{Parts: []int{2, 2}, Binary: make([]byte, 6), Offset: 4, Size: 10}, 0, false},
+		{"too many parts", RawFile{Parts: []int{5, 1}, Binary: make([]byte, 4), Size: 10}, 0, false},
+		{"short single", RawFile{Parts: []int{1, 1}, Binary: make([]byte, 4), Size: 10}, 0, false},
+		{"negative size", RawFile{Parts: []int{1, 1}, Size: -1}, 0, false},
+	}
+	for _, v := range tests {
+		size, ok := chunkLayout(&v.raw)
+		assert.Equal(t, v.expect, ok, v.name)
+		if v.expect {
+			assert.Equal(t, v.size, size, v.name)
+		}
+	}
+}
+
+func TestUploadParts(t *testing.T) {
+	u := &upload{
+		parts: map[int]int64{},
+	}
+	assert.Equal(t, 1, u.next())
+	assert.Equal(t, []int{}, u.received())
+
+	u.parts[3] = 2
+	u.parts[1] = 4
+	assert.Equal(t, 2, u.next())
+	assert.Equal(t, []int{1, 3}, u.received())
+	assert.Equal(t, int64(6), u.size())
+
+	u.parts[2] = 4
+	assert.Equal(t, 4, u.next())
+	assert.Equal(t, []int{1, 2, 3}, u.received())
+}
+
+func TestChunkRejected(t *testing.T) {
+	e, sess, store := newTestEngine()
+	e.Option.MaxFileSize = 100
+	e.Option.MaxChunkSize = 8
+
+	tests := []struct {
+		name string
+		raw  *RawFile
+	}{
+		{"huge offset", &RawFile{Parts: []int{2, 2}, Binary: []byte("ab"), Offset: 1 << 40, Size: 1<<40 + 2, Upload: "a"}},
+		{"offset not at part", &RawFile{Parts: []int{3, 2}, Binary: []byte("ab"), Offset: 50, Size: 6, Upload: "b"}},
+		{"file too large", &RawFile{Parts: []int{20, 1}, Binary: []byte("abcdefgh"), Size: 160, Upload: "c"}},
+		{"chunk too large", &RawFile{Parts: []int{2, 1}, Binary: []byte("abcdefghij"), Size: 20, Upload: "d"}},
+		{"malformed parts", &RawFile{Parts: []int{1}, Binary: []byte("ab"), Size: 2, Upload: "e"}},
+	}
+	for _, v := range tests {
+		c := newTestContext(e, sess, nil)
+		assert.False(t, e.fileHandler(c, map[string][]*RawFile{"File": {v.raw}}), v.name)
+		assert.Len(t, e.uploads, 0, v.name)
+		assert.Len(t, store.files, 0, v.name)
+	}
+
+	// 之後的區塊必須與第一個區塊所告知的檔案大小相同。
+	c := newTestContext(e, sess, nil)
+	e.fileHandler(c, map[string][]*RawFile{"File": {{Parts: []int{2, 1}, Binary: []byte("ab"), Size: 4, Upload: "f"}}})
+	c = newTestContext(e, sess, nil)
+	e.fileHandler(c, map[string][]*RawFile{"File": {{Parts: []int{3, 2}, Binary: []byte("ab"), Offset: 2, Size: 6, Upload: "f"}}})
+	next, received, _ := e.uploadNext(sess, "f")
+	assert.Equal(t, 2, next)
+	assert.Equal(t, []int{1}, received)
+}
+
+func TestUploadSweep(t *testing.T) {
+	e, sess, store := newTestEngine()
+	e.Option.UploadMaxAge = 100
+	e.Option.UploadExpiry = 1000
+	raw := &RawFile{Name: "a.txt", Parts: []int{2, 1}, Size: 4, Upload: "a"}
+	u, err := e.upload(sess, raw)
+	assert.NoError(t, err)
+
+	// 所屬階段暫時不在的上傳仍會被保留，讓客戶端重新連線後能繼續上傳。
+	delete(e.Sessions, sess.ID)
+	e.sweep()
+	assert.Contains(t, e.uploads, u.id)
+	assert.Contains(t, store.files, u.key)
+
+	// 超過保留時間的上傳則會被中止。
+	e.Option.Clock.(*FakeClock).Advance(time.Second * 101)
+	e.sweep()
+	assert.NotContains(t, e.uploads, u.id)
+	assert.NotContains(t, store.files, u.key)
+}
+
+func TestUploadAbortWhileFinishing(t *testing.T) {
+	e, sess, store := newTestEngine()
+	raw := &RawFile{Name: "a.txt", Parts: []int{1, 1}, Size: 2, Upload: "a"}
+	u, err := e.upload(sess, raw)
+	assert.NoError(t, err)
+	f := newFile(store, u.key, raw.Name, 2)
+
+	// 中止上傳與保存組合完畢的檔案可能會同時發生。
+	done := make(chan struct{})
+	go func() {
+		e.finishUpload(sess, raw, f)
+		close(done)
+	}()
+	e.abortUpload(u)
+	<-done
+	e.abortUpload(u)
+	assert.NotContains(t, store.files, u.key)
+}
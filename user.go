@@ -0,0 +1,98 @@
+package mego
+
+// SetUser 會將此階段與指定的使用者編號建立關聯，如此一來就能透過使用者編號找到其所有裝置的階段。
+// 傳入空字串則會解除此階段與使用者的關聯。
+// 建立關聯後，該使用者離線期間被放入信箱的事件會依序送給此階段。
+func (s *Session) SetUser(id string) {
+	// 複製的階段會替原本的階段建立關聯，否則索引中會留下複製品而無法被正確解除。
+	if s.origin != nil {
+		s.origin.SetUser(id)
+		return
+	}
+	e := s.engine
+	e.lock.Lock()
+	e.unbindUser(s)
+	s.user = id
+	if id == "" {
+		e.lock.Unlock()
+		return
+	}
+	if e.users == nil {
+		e.users = make(map[string][]*Session)
+	}
+	e.users[id] = append(e.users[id], s)
+	e.lock.Unlock()
+
+	e.deliver(s, userMailbox(id))
+}
+
+// User 會回傳此階段所關聯的使用者編號，沒有關聯時回傳空字串。
+func (s *Session) User() string {
+	if s.origin != nil {
+		return s.origin.User()
+	}
+	s.engine.lock.RLock()
+	defer s.engine.lock.RUnlock()
+	return s.user
+}
+
+// unbindUser 會將階段從使用者索引中移除，呼叫前必須先取得引擎的寫入鎖。
+func (e *Engine) unbindUser(s *Session) {
+	if s.user == "" {
+		return
+	}
+	list := e.users[s.user]
+	for i, v := range list {
+		if v == s {
+			list = append(list[:i], list[i+1:]...)
+			break
+		}
+	}
+	if len(list) == 0 {
+		delete(e.users, s.user)
+	} else {
+		e.users[s.user] = list
+	}
+	s.user = ""
+}
+
+// UserSessions 會回傳指定使用者目前所有的階段。
+func (e *Engine) UserSessions(id string) []*Session {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	list := make([]*Session, len(e.users[id]))
+	copy(list, e.users[id])
+	return list
+}
+
+// EmitToUser 會向指定使用者的所有階段廣播一個事件，此事件不需要事先訂閱。
+// 如果事件有開啟離線信箱，使用者不在線上時事件會被保留，並在使用者下次登入時送達。
+func (e *Engine) EmitToUser(id string, event string, result interface{}) error {
+	sessions := e.UserSessions(id)
+	option := e.eventOption(event)
+	if option.Mailbox {
+		return e.post(userMailbox(id), sessions, event, "", result)
+	}
+	if len(sessions) == 0 {
+		return ErrUserNotFound
+	}
+	return e.send(sessions, Response{
+		Event:  event,
+		Result: result,
+	}, option.Acknowledge)
+}
+
+// DisconnectUser 會以指定原因斷開指定使用者所有階段的連線，例如在使用者登出或被停權時使用。
+func (e *Engine) DisconnectUser(id string, reason DisconnectReason) error {
+	sessions := e.UserSessions(id)
+	if len(sessions) == 0 {
+		return ErrUserNotFound
+	}
+	var firstErr error
+	for _, v := range sessions {
+		if err := v.Disconnect(reason); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
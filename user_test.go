@@ -0,0 +1,26 @@
+package mego
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetUserOnCopy(t *testing.T) {
+	e, sess, _ := newTestEngine()
+	c := sess.Copy()
+
+	// 複製的階段會替原本的階段建立關聯。
+	c.SetUser("user")
+	assert.Equal(t, []*Session{sess}, e.UserSessions("user"))
+	assert.Equal(t, "user", sess.User())
+	assert.Equal(t, "user", c.User())
+
+	c.SetUser("other")
+	assert.Len(t, e.UserSessions("user"), 0)
+	assert.Equal(t, []*Session{sess}, e.UserSessions("other"))
+
+	c.SetUser("")
+	assert.Len(t, e.UserSessions("other"), 0)
+	assert.Equal(t, "", sess.User())
+}